@@ -0,0 +1,329 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tomasen/realip"
+	"gl_api.malyshev.io/internal/data"
+	"gl_api.malyshev.io/internal/validator"
+)
+
+// unlockUserHandler() досрочно снимаем блокировку входа с аккаунта
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.LoginAttempts.Unlock(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, data.AuditAccountUnlocked, "user", id, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "аккаунт разблокирован"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revokeUserTokens() отзываем все токены пользователя перечисленных scope
+func (app *application) revokeUserTokens(userID int64, scopes ...string) error {
+	for _, scope := range scopes {
+		err := app.models.Tokens.DeleteAllForUser(scope, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// adminUser() пользователь из :id, ответ 404 уже отправлен если вернулся nil
+func (app *application) adminUser(w http.ResponseWriter, r *http.Request) *data.User {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+
+	return user
+}
+
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Search    string
+		Activated *bool
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Search = app.readString(qs, "search", "")
+	input.Activated = app.readBool(qs, "activated", v)
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "email", "created_at", "-id", "-name", "-email", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.models.Users.GetAll(input.Search, input.Activated, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"users": users, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.adminUser(w, r)
+	if user == nil {
+		return
+	}
+
+	roles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user, "roles": roles, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateUserHandler() активация и блокировка аккаунта. У деактивированного отзываются все сессии,
+// у заблокированного - еще и API ключи. Активацию пользователь может вернуть себе сам через письмо,
+// блокировку снимает только администратор
+func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.adminUser(w, r)
+	if user == nil {
+		return
+	}
+
+	var input struct {
+		Activated *bool `json:"activated"`
+		Blocked   *bool `json:"blocked"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	before := *user
+
+	if input.Activated != nil {
+		user.Activated = *input.Activated
+	}
+
+	if input.Blocked != nil {
+		user.Blocked = *input.Blocked
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, data.AuditUserUpdated, "user", user.ID, diff(before, user))
+
+	var scopes []string
+
+	switch {
+	case user.Blocked:
+		scopes = []string{data.ScopeAuthentication, data.ScopeRefresh, data.ScopeAPIKey}
+	case !user.Activated:
+		scopes = []string{data.ScopeAuthentication, data.ScopeRefresh}
+	}
+
+	if scopes != nil {
+		err = app.revokeUserTokens(user.ID, scopes...)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.audit(r, data.AuditTokenRevoked, "user", user.ID, map[string]any{"scopes": scopes})
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// forcePasswordResetHandler() принудительный сброс: старый пароль перестает действовать,
+// сессии отзываются, пользователю уходит письмо со сбросом пароля
+func (app *application) forcePasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.adminUser(w, r)
+	if user == nil {
+		return
+	}
+
+	randomBytes := make([]byte, 32)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// случайный пароль никто не знает, войти можно будет только после сброса
+	err = user.Password.Set(base64.RawStdEncoding.EncodeToString(randomBytes))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.revokeUserTokens(user.ID, data.ScopeAuthentication, data.ScopeRefresh, data.ScopePasswordReset)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, data.AuditTokenRevoked, "user", user.ID, map[string]any{
+		"scopes": []string{data.ScopeAuthentication, data.ScopeRefresh, data.ScopePasswordReset},
+		"reason": "password_reset",
+	})
+
+	token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, data.AuditTokenCreated, "user", user.ID, map[string]any{"scope": data.ScopePasswordReset})
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"passwordResetToken": token.Plaintext,
+		}
+
+		err := app.mailer.Send(user.Email, "token_password_reset.tmpl.html", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"message": "пароль сброшен, пользователю отправлено письмо"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revokeUserTokensHandler() отзываем все сессии и API ключи пользователя
+func (app *application) revokeUserTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.adminUser(w, r)
+	if user == nil {
+		return
+	}
+
+	err := app.revokeUserTokens(user.ID, data.ScopeAuthentication, data.ScopeRefresh, data.ScopeAPIKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, data.AuditTokenRevoked, "user", user.ID, map[string]any{"scopes": []string{data.ScopeAuthentication, data.ScopeRefresh, data.ScopeAPIKey}})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "все токены пользователя отозваны"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// impersonateUserHandler() короткоживущий токен, с которым администратор видит API так же, как пользователь
+func (app *application) impersonateUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.adminUser(w, r)
+	if user == nil {
+		return
+	}
+
+	admin := app.contextGetUser(r)
+
+	v := validator.New()
+
+	if v.Check(user.ID != admin.ID, "id", "нельзя войти от имени самого себя"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	token, err := app.models.Tokens.NewImpersonation(user.ID, admin.ID, app.config.auth.impersonationTTL, realip.FromRequest(r), r.UserAgent())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.logger.PrintInfo("вход от имени пользователя", map[string]string{
+		"user_id":         strconv.FormatInt(user.ID, 10),
+		"impersonator_id": strconv.FormatInt(admin.ID, 10),
+		"expiry":          token.Expiry.Format(time.RFC3339),
+	})
+
+	app.audit(r, data.AuditTokenCreated, "user", user.ID, map[string]any{"scope": "impersonation", "expiry": token.Expiry})
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
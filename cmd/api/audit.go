@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+
+	"github.com/tomasen/realip"
+	"gl_api.malyshev.io/internal/data"
+	"gl_api.malyshev.io/internal/validator"
+)
+
+// audit() пишем событие в журнал от имени текущего пользователя. Журнал не должен ломать основной запрос,
+// поэтому ошибку записи только логируем
+func (app *application) audit(r *http.Request, action, targetType string, targetID int64, details map[string]any) {
+	event := &data.AuditEvent{
+		Action:     action,
+		TargetType: targetType,
+		IP:         realip.FromRequest(r),
+		RequestID:  app.contextGetRequestID(r),
+		Details:    details,
+	}
+
+	if targetID != 0 {
+		event.TargetID = &targetID
+	}
+
+	// до authenticate (или без заголовка) в контексте пользователя нет - это аноним
+	if user, ok := r.Context().Value(userContextKey).(*data.User); ok && !user.IsAnonymous() {
+		event.ActorID = &user.ID
+
+		if app.contextIsImpersonated(r) {
+			event.ImpersonatorID = &app.contextGetRealUser(r).ID
+		}
+	}
+
+	err := app.models.Audit.Insert(event)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// auditAs() событие, автор которого еще не в контексте запроса, например успешный вход
+func (app *application) auditAs(r *http.Request, actorID int64, action, targetType string, targetID int64, details map[string]any) {
+	app.audit(app.contextSetUser(r, &data.User{ID: actorID}), action, targetType, targetID, details)
+}
+
+// diff() изменившиеся поля в виде {"поле": {"before": ..., "after": ...}}, сравниваем JSON представления
+func diff(before, after any) map[string]any {
+	b := toJSONMap(before)
+	a := toJSONMap(after)
+
+	changes := map[string]any{}
+
+	for key, value := range a {
+		if !reflect.DeepEqual(b[key], value) {
+			changes[key] = map[string]any{"before": b[key], "after": value}
+		}
+	}
+
+	for key, value := range b {
+		if _, ok := a[key]; !ok {
+			changes[key] = map[string]any{"before": value, "after": nil}
+		}
+	}
+
+	return changes
+}
+
+func toJSONMap(v any) map[string]any {
+	m := map[string]any{}
+
+	js, err := json.Marshal(v)
+	if err != nil {
+		return m
+	}
+
+	_ = json.Unmarshal(js, &m)
+	return m
+}
+
+// listAuditEventsHandler() журнал аудита с отбором по действию, автору и объекту
+func (app *application) listAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.AuditFilter
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Action = app.readString(qs, "action", "")
+	input.ActorID = int64(app.readInt(qs, "actor_id", 0, v))
+	input.TargetType = app.readString(qs, "target_type", "")
+	input.TargetID = int64(app.readInt(qs, "target_id", 0, v))
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.SortSafelist = []string{"id", "created_at", "action", "-id", "-created_at", "-action"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	events, metadata, err := app.models.Audit.GetAll(input.AuditFilter, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"events": events, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -11,7 +11,14 @@ import (
 type contextKey string
 
 // конвертнем строку в тип contextKey и присвоит это константе - будем использовать эту константу как ключ контекста
-const userContextKey = contextKey("user")
+const (
+	userContextKey        = contextKey("user")
+	tokenContextKey       = contextKey("token")
+	permissionsContextKey = contextKey("permissions")
+	realUserContextKey    = contextKey("real_user")
+	requestIDContextKey   = contextKey("request_id")
+	apiKeyContextKey      = contextKey("api_key")
+)
 
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
@@ -26,3 +33,75 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+// contextSetToken() сохраняем в контекст токен, с которым пришел запрос, чтобы его можно было отозвать
+func (app *application) contextSetToken(r *http.Request, token string) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetToken(r *http.Request) string {
+	token, ok := r.Context().Value(tokenContextKey).(string)
+	if !ok {
+		panic("отсутствует значение для токена в контексте запроса")
+	}
+
+	return token
+}
+
+// contextSetPermissions() права, уже известные на этапе аутентификации (например из JWT), чтобы не ходить за ними в базу
+func (app *application) contextSetPermissions(r *http.Request, permissions data.Permissions) *http.Request {
+	ctx := context.WithValue(r.Context(), permissionsContextKey, permissions)
+	return r.WithContext(ctx)
+}
+
+// contextGetPermissions() в отличие от пользователя права в контексте есть не всегда, поэтому без паники
+func (app *application) contextGetPermissions(r *http.Request) (data.Permissions, bool) {
+	permissions, ok := r.Context().Value(permissionsContextKey).(data.Permissions)
+	return permissions, ok
+}
+
+// contextSetRealUser() при входе от имени пользователя в контексте два пользователя:
+// contextGetUser() - тот, от чьего имени идет запрос, contextGetRealUser() - администратор, который его делает
+func (app *application) contextSetRealUser(r *http.Request, user *data.User) *http.Request {
+	ctx := context.WithValue(r.Context(), realUserContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// contextGetRealUser() кто на самом деле делает запрос, без входа от имени другого совпадает с contextGetUser()
+func (app *application) contextGetRealUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(realUserContextKey).(*data.User)
+	if !ok {
+		return app.contextGetUser(r)
+	}
+
+	return user
+}
+
+// contextIsImpersonated() запрос сделан администратором от имени другого пользователя
+func (app *application) contextIsImpersonated(r *http.Request) bool {
+	_, ok := r.Context().Value(realUserContextKey).(*data.User)
+	return ok
+}
+
+// contextSetAPIKey() запрос аутентифицирован API ключом, а не сессией пользователя
+func (app *application) contextSetAPIKey(r *http.Request) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, true)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextIsAPIKey(r *http.Request) bool {
+	isAPIKey, _ := r.Context().Value(apiKeyContextKey).(bool)
+	return isAPIKey
+}
+
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestID() пустая строка, если запрос прошел мимо middleware requestID
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
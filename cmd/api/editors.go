@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"gl_api.malyshev.io/internal/data"
+	"gl_api.malyshev.io/internal/validator"
+)
+
+// canManageMovie() удалять фильм и раздавать права на него может владелец или обладатель movies:admin
+func (app *application) canManageMovie(r *http.Request, movie *data.Movie) (bool, error) {
+	user := app.contextGetUser(r)
+
+	if movie.CreatedBy != nil && *movie.CreatedBy == user.ID {
+		return true, nil
+	}
+
+	permissions, err := app.userPermissions(r)
+	if err != nil {
+		return false, err
+	}
+
+	return permissions.Include("movies:admin"), nil
+}
+
+// canEditMovie() редактировать фильм могут еще и те, с кем владелец им поделился
+func (app *application) canEditMovie(r *http.Request, movie *data.Movie) (bool, error) {
+	ok, err := app.canManageMovie(r, movie)
+	if err != nil || ok {
+		return ok, err
+	}
+
+	return app.models.Movies.IsEditor(movie.ID, app.contextGetUser(r).ID)
+}
+
+// managedMovie() фильм из :id, если текущий пользователь может им управлять. Ответ с ошибкой уже записан, если вернули nil
+func (app *application) managedMovie(w http.ResponseWriter, r *http.Request) *data.Movie {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+
+	ok, err := app.canManageMovie(r, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil
+	}
+
+	if !ok {
+		app.notPermittedResponse(w, r)
+		return nil
+	}
+
+	return movie
+}
+
+func (app *application) listMovieEditorsHandler(w http.ResponseWriter, r *http.Request) {
+	movie := app.managedMovie(w, r)
+	if movie == nil {
+		return
+	}
+
+	editors, err := app.models.Movies.GetEditors(movie.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"editors": editors}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// addMovieEditorHandler() владелец делится правом редактирования фильма с другим пользователем
+func (app *application) addMovieEditorHandler(w http.ResponseWriter, r *http.Request) {
+	movie := app.managedMovie(w, r)
+	if movie == nil {
+		return
+	}
+
+	var input struct {
+		UserID int64 `json:"user_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.UserID > 0, "user_id", "должен быть задан")
+	v.Check(movie.CreatedBy == nil || *movie.CreatedBy != input.UserID, "user_id", "владелец и так может редактировать фильм")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.models.Users.Get(input.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("user_id", "пользователь не найден")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Movies.AddEditor(movie.ID, input.UserID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, data.AuditPermissionGranted, "movie", movie.ID, map[string]any{"editor_id": input.UserID})
+
+	editors, err := app.models.Movies.GetEditors(movie.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"editors": editors}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) removeMovieEditorHandler(w http.ResponseWriter, r *http.Request) {
+	movie := app.managedMovie(w, r)
+	if movie == nil {
+		return
+	}
+
+	userID, err := strconv.ParseInt(httprouter.ParamsFromContext(r.Context()).ByName("user_id"), 10, 64)
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Movies.RemoveEditor(movie.ID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, data.AuditPermissionRevoked, "movie", movie.ID, map[string]any{"editor_id": userID})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "право редактирования отозвано"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
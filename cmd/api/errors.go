@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // lopError() общий метод хелпер для логирования сообщений, позже заменю на структурный логер
@@ -10,6 +13,7 @@ func (app *application) logError(r *http.Request, err error) {
 	app.logger.PrintError(err, map[string]string{
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
+		"request_id":     app.contextGetRequestID(r),
 	})
 }
 
@@ -88,3 +92,30 @@ func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Requ
 	message := "недостаточно привилегий для доступа"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+func (app *application) impersonationForbiddenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "действие недоступно при входе от имени другого пользователя"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) apiKeyForbiddenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "действие недоступно по API ключу, нужен вход по паролю"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) totpUnavailableResponse(w http.ResponseWriter, r *http.Request) {
+	message := "проверка кодов 2FA сейчас недоступна, войдите с кодом восстановления"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
+}
+
+func (app *application) otpRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "для этого аккаунта включена двухфакторная аутентификация, нужен одноразовый код в поле otp"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) tooManyLoginAttemptsResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	message := "слишком много неудачных попыток входа, повторите позже"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
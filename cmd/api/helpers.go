@@ -9,6 +9,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"gl_api.malyshev.io/internal/validator"
@@ -145,6 +146,43 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// readTime() хелпер для получения времени в RFC3339 или даты 2006-01-02, нулевое время если параметр не задан
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err == nil {
+		return t
+	}
+
+	t, err = time.Parse(time.DateOnly, s)
+	if err != nil {
+		v.AddError(key, "должно быть датой 2006-01-02 или временем RFC3339")
+		return time.Time{}
+	}
+
+	return t
+}
+
+// readBool() хелпер для получения true/false из строки, nil если параметр не задан
+func (app *application) readBool(qs url.Values, key string, v *validator.Validator) *bool {
+	s := qs.Get(key)
+	if s == "" {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "должно быть true или false")
+		return nil
+	}
+
+	return &b
+}
+
 // background() метод для перехвата  паники внутри горутины
 func (app *application) background(fn func()) {
 	app.wg.Add(1)
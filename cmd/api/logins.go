@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"gl_api.malyshev.io/internal/data"
+)
+
+// параметры экспоненциальной задержки между попытками входа
+const (
+	loginWindow      = 15 * time.Minute // за какое время считаем неудачные попытки
+	loginFreeByEmail = 3                // столько неудач по одной почте проходят без задержки
+	loginFreeByIP    = 10               // с одного IP может ходить много пользователей, поэтому порог выше
+	loginBaseDelay   = time.Second
+	loginMaxBackoff  = 5 * time.Minute
+)
+
+// backoff() задержка после failures неудач: 1с, 2с, 4с... но не больше loginMaxBackoff
+func backoff(failures, free int) time.Duration {
+	if failures < free {
+		return 0
+	}
+
+	delay := loginBaseDelay
+	for i := free; i < failures && delay < loginMaxBackoff; i++ {
+		delay *= 2
+	}
+
+	return min(delay, loginMaxBackoff)
+}
+
+// loginBackoff() сколько еще нужно подождать до следующей попытки входа, 0 - можно пробовать
+func (app *application) loginBackoff(email, ip string) (time.Duration, error) {
+	failures, err := app.models.LoginAttempts.GetFailures(email, ip, time.Now().Add(-loginWindow))
+	if err != nil {
+		return 0, err
+	}
+
+	retryAfter := max(
+		time.Until(failures.LastByEmail.Add(backoff(failures.ByEmail, loginFreeByEmail))),
+		time.Until(failures.LastByIP.Add(backoff(failures.ByIP, loginFreeByIP))),
+	)
+
+	return max(retryAfter, 0), nil
+}
+
+// loginFailed() учитываем неудачную попытку (reason - причина для журнала аудита), при превышении порога блокируем аккаунт и сообщаем владельцу
+func (app *application) loginFailed(w http.ResponseWriter, r *http.Request, user *data.User, email, ip, reason string) {
+	err := app.models.LoginAttempts.RecordFailure(email, ip)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var userID int64
+	if user != nil {
+		userID = user.ID
+	}
+
+	app.audit(r, data.AuditLoginFailed, "user", userID, map[string]any{"email": email, "reason": reason})
+
+	if user != nil {
+		failures, err := app.models.LoginAttempts.GetFailures(email, ip, time.Now().Add(-loginWindow))
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// уже заблокированный аккаунт повторно не блокируем, чтобы не продлевать блокировку и не слать письма на каждую попытку
+		_, err = app.models.LoginAttempts.LockedUntil(user.ID)
+		locked := err == nil
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !locked && failures.ByEmail >= app.config.login.maxAttempts {
+			lockedUntil := time.Now().Add(app.config.login.lockout)
+
+			err = app.models.LoginAttempts.Lock(user.ID, lockedUntil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			app.audit(r, data.AuditAccountLocked, "user", user.ID, map[string]any{"locked_until": lockedUntil})
+
+			app.background(func() {
+				data := map[string]interface{}{
+					"lockedUntil": lockedUntil.Format(time.RFC1123),
+					"ip":          ip,
+				}
+
+				err := app.mailer.Send(user.Email, "user_lockout.tmpl.html", data)
+				if err != nil {
+					app.logger.PrintError(err, nil)
+				}
+			})
+		}
+	}
+
+	// ответ одинаковый для неизвестной почты, неверного пароля и блокировки
+	app.invalidCredentialsResponse(w, r)
+}
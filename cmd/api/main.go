@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"database/sql"
+	"encoding/base64"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
@@ -17,6 +19,7 @@ import (
 	_ "github.com/lib/pq"
 	"gl_api.malyshev.io/internal/data"
 	"gl_api.malyshev.io/internal/jsonlog"
+	"gl_api.malyshev.io/internal/jwt"
 	"gl_api.malyshev.io/internal/mailer"
 )
 
@@ -50,6 +53,33 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	auth struct {
+		mode            string
+		accessTokenTTL  time.Duration
+		refreshTokenTTL time.Duration
+		// impersonationTTL время жизни токена входа администратора от имени пользователя
+		impersonationTTL time.Duration
+	}
+	jwt struct {
+		keyID string
+		keys  []jwt.Key
+	}
+	totp struct {
+		issuer string
+		key    []byte
+	}
+	login struct {
+		maxAttempts int
+		lockout     time.Duration
+	}
+	cache struct {
+		size int
+		ttl  time.Duration
+	}
+	account struct {
+		deletionGrace time.Duration
+		defaultRole   string
+	}
 }
 
 // application hold the dependencies for HTTP handlers, helpers, middleware
@@ -58,7 +88,10 @@ type application struct {
 	logger *jsonlog.Logger
 	models data.Models
 	mailer mailer.Mailer
+	signer *jwt.Signer
 	wg     sync.WaitGroup
+	// done закрывается при остановке сервера, по нему выходят периодические фоновые задачи
+	done chan struct{}
 }
 
 func main() {
@@ -87,6 +120,58 @@ func main() {
 		return nil
 	})
 
+	flag.StringVar(&cfg.auth.mode, "auth-mode", "token", "Режим аутентификации (token|jwt)")
+	flag.DurationVar(&cfg.auth.accessTokenTTL, "auth-access-ttl", 15*time.Minute, "Время жизни токена доступа")
+	flag.DurationVar(&cfg.auth.refreshTokenTTL, "auth-refresh-ttl", 30*24*time.Hour, "Время жизни токена обновления")
+	flag.DurationVar(&cfg.auth.impersonationTTL, "auth-impersonation-ttl", 15*time.Minute, "Время жизни токена входа от имени пользователя")
+
+	flag.StringVar(&cfg.jwt.keyID, "jwt-kid", "", "kid ключа, которым подписываются новые JWT")
+	flag.Func("jwt-keys", "Ключи JWT в формате kid:alg:base64 (через пробел), alg - HS256 или EdDSA", func(s string) error {
+		for _, field := range strings.Fields(s) {
+			parts := strings.SplitN(field, ":", 3)
+			if len(parts) != 3 {
+				return fmt.Errorf("неверный формат ключа %q", field)
+			}
+
+			raw, err := base64.StdEncoding.DecodeString(parts[2])
+			if err != nil {
+				return fmt.Errorf("ключ %q: %w", parts[0], err)
+			}
+
+			key, err := jwt.NewKey(parts[0], parts[1], raw)
+			if err != nil {
+				return err
+			}
+
+			cfg.jwt.keys = append(cfg.jwt.keys, key)
+		}
+		return nil
+	})
+
+	flag.IntVar(&cfg.login.maxAttempts, "login-max-attempts", 10, "Неудачных попыток входа до временной блокировки аккаунта")
+	flag.DurationVar(&cfg.login.lockout, "login-lockout", 30*time.Minute, "Длительность блокировки аккаунта")
+
+	flag.IntVar(&cfg.cache.size, "cache-size", 10000, "Максимум записей в кеше аутентификации")
+	flag.DurationVar(&cfg.cache.ttl, "cache-ttl", time.Minute, "Время жизни записей кеша аутентификации, 0 - кеш выключен")
+
+	flag.DurationVar(&cfg.account.deletionGrace, "account-deletion-grace", 7*24*time.Hour, "Льготный период перед удалением аккаунта")
+	flag.StringVar(&cfg.account.defaultRole, "default-role", "viewer", "Роль, назначаемая новым пользователям при регистрации")
+
+	flag.StringVar(&cfg.totp.issuer, "totp-issuer", "GL API", "Имя сервиса в приложении-аутентификаторе")
+	flag.Func("totp-key", "Ключ шифрования секретов 2FA (32 байта в base64)", func(s string) error {
+		key, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return err
+		}
+
+		if len(key) != 32 {
+			return errors.New("ключ должен быть 32 байта")
+		}
+
+		cfg.totp.key = key
+		return nil
+	})
+
 	displayVersion := flag.Bool("version", false, "Отобразить текущую версию и выйти")
 
 	flag.Parse()
@@ -100,6 +185,32 @@ func main() {
 	//init new logger
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
+	// подписант JWT нужен и для проверки уже выданных токенов, поэтому собираем его при наличии ключей в любом режиме
+	var signer *jwt.Signer
+
+	if len(cfg.jwt.keys) > 0 {
+		var err error
+
+		signer, err = jwt.New(cfg.jwt.keyID, cfg.jwt.keys...)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	}
+
+	switch cfg.auth.mode {
+	case "token":
+	case "jwt":
+		if signer == nil {
+			logger.PrintFatal(errors.New("для режима jwt нужно задать -jwt-keys и -jwt-kid"), nil)
+		}
+	default:
+		logger.PrintFatal(fmt.Errorf("неизвестный режим аутентификации %q", cfg.auth.mode), nil)
+	}
+
+	if cfg.totp.key == nil {
+		logger.PrintInfo("не задан -totp-key, подключение 2FA выключено, вход с 2FA - только по кодам восстановления", nil)
+	}
+
 	// connect to DB
 	db, err := openDB(cfg)
 	if err != nil {
@@ -110,6 +221,23 @@ func main() {
 
 	logger.PrintInfo("database connection successfully established", nil)
 
+	var authCache *data.AuthCache
+	if cfg.cache.ttl > 0 && cfg.cache.size > 0 {
+		authCache = data.NewAuthCache(cfg.cache.size, cfg.cache.ttl)
+	}
+
+	models := data.NewModels(db, authCache)
+
+	// опечатка в -default-role иначе молча оставила бы новых пользователей без прав
+	exists, err := models.Roles.Exists(cfg.account.defaultRole)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	if !exists {
+		logger.PrintFatal(fmt.Errorf("роль по умолчанию %q не найдена", cfg.account.defaultRole), nil)
+	}
+
 	// для роута отладки /debug/vars
 	// версия
 	expvar.NewString("version").Set(version)
@@ -121,6 +249,10 @@ func main() {
 	expvar.Publish("database", expvar.Func(func() any {
 		return db.Stats()
 	}))
+	// попадания в кеш аутентификации
+	expvar.Publish("auth_cache", expvar.Func(func() any {
+		return authCache.Stats()
+	}))
 	// текущая метка времени unixtimestamp
 	expvar.Publish("timestamp", expvar.Func(func() any {
 		return time.Now().Unix()
@@ -130,10 +262,14 @@ func main() {
 	app := &application{
 		config: cfg,
 		logger: logger,
-		models: data.NewModels(db),
+		models: models,
 		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		signer: signer,
+		done:   make(chan struct{}),
 	}
 
+	app.background(app.deleteDueAccounts)
+
 	err = app.serve()
 	if err != nil {
 		logger.PrintFatal(err, nil)
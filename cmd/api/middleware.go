@@ -1,10 +1,13 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"expvar"
 	"fmt"
 	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -13,6 +16,7 @@ import (
 	"github.com/felixge/httpsnoop"
 	"github.com/tomasen/realip"
 	"gl_api.malyshev.io/internal/data"
+	"gl_api.malyshev.io/internal/jwt"
 	"gl_api.malyshev.io/internal/validator"
 	"golang.org/x/time/rate"
 )
@@ -103,12 +107,53 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 
 		headerParts := strings.Split(aunthorizationHeader, " ")
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		if len(headerParts) != 2 {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		// Bearer - токены сессий, ApiKey - долгоживущие ключи машинных клиентов
+		var scope string
+
+		switch headerParts[0] {
+		case "Bearer":
+			scope = data.ScopeAuthentication
+		case "ApiKey":
+			scope = data.ScopeAPIKey
+		default:
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
 
 		token := headerParts[1]
+
+		// JWT проверяем локально без похода в базу, пользователь и права берутся из claims
+		if scope == data.ScopeAuthentication && app.signer != nil && jwt.Looks(token) {
+			claims, err := app.signer.Verify(token, time.Now())
+			if err != nil {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			id, err := strconv.ParseInt(claims.Subject, 10, 64)
+			if err != nil {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			user := &data.User{
+				ID:        id,
+				Activated: claims.Activated,
+			}
+
+			r = app.contextSetUser(r, user)
+			r = app.contextSetToken(r, token)
+			r = app.contextSetPermissions(r, data.Permissions(claims.Permissions))
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		v := validator.New()
 
 		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
@@ -116,7 +161,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		user, impersonatorID, err := app.models.Users.GetForTokenWithImpersonator(scope, token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -127,7 +172,78 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		// токены отзываются при блокировке, но запись могла прийти из кеша другого инстанса.
+		// JWT проверяются без базы, заблокированный теряет доступ с истечением токена доступа
+		if user.Blocked {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		// вход от имени пользователя действует, пока у администратора есть users:admin
+		if impersonatorID != 0 {
+			realUser, err := app.models.Users.Get(impersonatorID)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					app.invalidAuthenticationTokenResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+
+			permissions, err := app.models.Permissions.GetAllForUser(realUser.ID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			if realUser.Blocked || !permissions.Include("users:admin") {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			app.logger.PrintInfo("запрос от имени пользователя", map[string]string{
+				"user_id":         strconv.FormatInt(user.ID, 10),
+				"impersonator_id": strconv.FormatInt(realUser.ID, 10),
+				"method":          r.Method,
+				"uri":             r.URL.RequestURI(),
+			})
+
+			r = app.contextSetRealUser(r, realUser)
+		}
+
+		// время последнего использования не критично, ошибку только логируем
+		err = app.models.Tokens.Touch(scope, token)
+		if err != nil {
+			app.logError(r, err)
+		}
+
+		// у ключа действуют только те его права, которые до сих пор есть у владельца
+		if scope == data.ScopeAPIKey {
+			key, err := app.models.APIKeys.GetForPlaintext(token)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					app.invalidAuthenticationTokenResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+
+			permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			r = app.contextSetPermissions(r, key.Permissions.Intersect(permissions))
+			r = app.contextSetAPIKey(r)
+		}
+
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, token)
 
 		next.ServeHTTP(w, r)
 	})
@@ -163,12 +279,44 @@ func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.Han
 	})
 }
 
+// forbidImpersonation() действия с учетными данными (ключи, 2FA, профиль) делает только сам пользователь
+func (app *application) forbidImpersonation(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.contextIsImpersonated(r) {
+			app.impersonationForbiddenResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forbidAPIKey() учетную запись (профиль, ключи, 2FA, сессии) API ключом не меняем,
+// иначе ключ с урезанными правами мог бы выпустить себе ключ с полными
+func (app *application) forbidAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.contextIsAPIKey(r) {
+			app.apiKeyForbiddenResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// userPermissions() права из контекста (JWT, API ключ), иначе из базы
+func (app *application) userPermissions(r *http.Request) (data.Permissions, error) {
+	if permissions, ok := app.contextGetPermissions(r); ok {
+		return permissions, nil
+	}
+
+	return app.models.Permissions.GetAllForUser(app.contextGetUser(r).ID)
+}
+
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		user := app.contextGetUser(r)
-
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
-		if err != err {
+		permissions, err := app.userPermissions(r)
+		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
@@ -214,6 +362,33 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 	})
 }
 
+// requestIDRX какие идентификаторы запроса принимаем от прокси, остальные заменяем своими
+var requestIDRX = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestID() у каждого запроса есть идентификатор: берем X-Request-ID от прокси или генерируем свой,
+// отдаем его в ответе и пишем в логи и журнал аудита
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+
+		if !requestIDRX.MatchString(id) {
+			randomBytes := make([]byte, 16)
+
+			_, err := rand.Read(randomBytes)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			id = hex.EncodeToString(randomBytes)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		next.ServeHTTP(w, app.contextSetRequestID(r, id))
+	})
+}
+
 func (app *application) metrics(next http.Handler) http.Handler {
 	totalRequestsReceived := expvar.NewInt("total_requests_received")
 	totalResponsesSent := expvar.NewInt("total_responses_sent")
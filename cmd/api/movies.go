@@ -12,10 +12,11 @@ import (
 
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title   string       `json:"title"`
-		Year    int32        `json:"year"`
-		Runtime data.Runtime `json:"runtime"`
-		Genres  []string     `json:"genres"`
+		Title    string       `json:"title"`
+		Year     int32        `json:"year"`
+		Runtime  data.Runtime `json:"runtime"`
+		Genres   []string     `json:"genres"`
+		Language string       `json:"language"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -24,12 +25,21 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	user := app.contextGetUser(r)
+
 	movie := &data.Movie{
-		Title:   input.Title,
-		Year:    input.Year,
-		Runtime: input.Runtime,
-		Genres:  input.Genres,
+		Title:     input.Title,
+		Year:      input.Year,
+		Runtime:   input.Runtime,
+		Genres:    input.Genres,
+		Language:  input.Language,
+		CreatedBy: &user.ID,
+	}
+
+	if movie.Language == "" {
+		movie.Language = data.DetectLanguage(movie.Title)
 	}
+
 	// Validation section
 	v := validator.New()
 
@@ -44,6 +54,8 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	app.audit(r, data.AuditMovieCreated, "movie", movie.ID, map[string]any{"after": movie})
+
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
 
@@ -116,6 +128,17 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	ok, err := app.canEditMovie(r, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
 	if r.Header.Get("X-Expected-Version") != "" {
 		if strconv.FormatInt(int64(movie.Version), 32) != r.Header.Get("X-Expected-Version") {
 			app.editConflictResponse(w, r)
@@ -124,10 +147,11 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
+		Title    *string       `json:"title"`
+		Year     *int32        `json:"year"`
+		Runtime  *data.Runtime `json:"runtime"`
+		Genres   []string      `json:"genres"`
+		Language *string       `json:"language"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -136,6 +160,8 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	before := *movie
+
 	if input.Year != nil {
 		movie.Year = *input.Year
 	}
@@ -152,6 +178,14 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		movie.Title = *input.Title
 	}
 
+	// язык определялся по старому названию, без явного language определяем заново
+	switch {
+	case input.Language != nil:
+		movie.Language = *input.Language
+	case input.Title != nil && *input.Title != before.Title:
+		movie.Language = data.DetectLanguage(movie.Title)
+	}
+
 	// копируем новые данные в данные из базы
 
 	v := validator.New()
@@ -172,6 +206,8 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	app.audit(r, data.AuditMovieUpdated, "movie", movie.ID, diff(before, movie))
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -185,7 +221,7 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Delete(id)
+	movie, err := app.models.Movies.Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -196,6 +232,31 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// удалить фильм может только владелец, редакторам это не доступно
+	ok, err := app.canManageMovie(r, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	err = app.models.Movies.Delete(movie.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, data.AuditMovieDeleted, "movie", movie.ID, map[string]any{"before": movie})
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"message": "фильм удален"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -204,8 +265,7 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title  string
-		Genres []string
+		data.MovieFilters
 		data.Filters
 	}
 
@@ -214,24 +274,66 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	qs := r.URL.Query()
 
 	input.Title = app.readString(qs, "title", "")
+	input.Search = app.readString(qs, "search", data.SearchFTS)
+	input.Language = app.readString(qs, "lang", "")
 	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.GenresMode = app.readString(qs, "genres_mode", data.GenresAll)
+	input.YearMin = app.readInt(qs, "year_min", 0, v)
+	input.YearMax = app.readInt(qs, "year_max", 0, v)
+	input.RuntimeMin = app.readInt(qs, "runtime_min", 0, v)
+	input.RuntimeMax = app.readInt(qs, "runtime_max", 0, v)
+	input.CreatedAfter = app.readTime(qs, "created_after", v)
+	input.CreatedBefore = app.readTime(qs, "created_before", v)
+	facets := app.readCSV(qs, "facets", []string{})
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime", data.SortRelevance}
+	input.Filters.After = app.readString(qs, "after", "")
+	input.Filters.Before = app.readString(qs, "before", "")
+
+	// общее количество по умолчанию считаем только для навигации по номеру страницы
+	input.Filters.SkipTotal = input.Filters.After != "" || input.Filters.Before != ""
+	if total := app.readBool(qs, "total", v); total != nil {
+		input.Filters.SkipTotal = !*total
+	}
+
+	data.ValidateMovieFilters(v, input.MovieFilters)
+
+	for _, facet := range facets {
+		v.Check(validator.In(facet, data.FacetSafelist...), "facets", "неизвестный фасет: "+facet)
+	}
+	v.Check(validator.Unique(facets), "facets", "не должно содержать повторов")
+
+	// релевантность считается от поискового запроса и в курсор не сохраняется
+	if input.Filters.Sort == data.SortRelevance {
+		v.Check(input.Title != "", "sort", "relevance используется только вместе с title")
+		v.Check(input.Filters.After == "" && input.Filters.Before == "", "sort", "relevance не используется вместе с курсором")
+	}
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.models.Movies.GetAll(input.MovieFilters, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	env := envelope{"movies": movies, "metadata": metadata}
+
+	// фасеты считаются по всей выборке, а не по текущей странице
+	if len(facets) > 0 {
+		env["facets"], err = app.models.Movies.Facets(input.MovieFilters, facets)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -21,14 +21,61 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
 	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/editors", app.requirePermission("movies:write", app.listMovieEditorsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/editors", app.requirePermission("movies:write", app.addMovieEditorHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/editors/:user_id", app.requirePermission("movies:write", app.removeMovieEditorHandler))
 
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/email", app.confirmEmailChangeHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me", app.requireActivatedUser(app.forbidAPIKey(app.forbidImpersonation(app.updateCurrentUserHandler))))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me", app.requireAuthenticatedUser(app.forbidAPIKey(app.forbidImpersonation(app.deleteCurrentUserHandler))))
+	router.HandlerFunc(http.MethodPut, "/v1/users/deletion", app.confirmAccountDeletionHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/deletion", app.requireAuthenticatedUser(app.forbidAPIKey(app.forbidImpersonation(app.cancelAccountDeletionHandler))))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/export", app.requireAuthenticatedUser(app.forbidAPIKey(app.forbidImpersonation(app.exportUserDataHandler))))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/sessions", app.requireAuthenticatedUser(app.forbidAPIKey(app.listSessionsHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/sessions/:id", app.requireAuthenticatedUser(app.forbidAPIKey(app.forbidImpersonation(app.deleteSessionHandler))))
+
+	// без ключа шифрования секретов 2FA выключена целиком
+	if app.config.totp.key != nil {
+		router.HandlerFunc(http.MethodPost, "/v1/users/me/totp", app.requireActivatedUser(app.forbidAPIKey(app.forbidImpersonation(app.enrollTOTPHandler))))
+		router.HandlerFunc(http.MethodPut, "/v1/users/me/totp/confirmed", app.requireActivatedUser(app.forbidAPIKey(app.forbidImpersonation(app.confirmTOTPHandler))))
+		router.HandlerFunc(http.MethodDelete, "/v1/users/me/totp", app.requireActivatedUser(app.forbidAPIKey(app.forbidImpersonation(app.disableTOTPHandler))))
+	}
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/api-keys", app.requireActivatedUser(app.forbidAPIKey(app.forbidImpersonation(app.listAPIKeysHandler))))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/api-keys", app.requireActivatedUser(app.forbidAPIKey(app.forbidImpersonation(app.createAPIKeyHandler))))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/api-keys/:id", app.requireActivatedUser(app.forbidAPIKey(app.forbidImpersonation(app.showAPIKeyHandler))))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me/api-keys/:id", app.requireActivatedUser(app.forbidAPIKey(app.forbidImpersonation(app.updateAPIKeyHandler))))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/api-keys/:id", app.requireActivatedUser(app.forbidAPIKey(app.forbidImpersonation(app.deleteAPIKeyHandler))))
 
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requireAuthenticatedUser(app.deleteAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication/all", app.requireAuthenticatedUser(app.forbidAPIKey(app.forbidImpersonation(app.deleteAllAuthenticationTokensHandler))))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/admin/permissions", app.requirePermission("users:admin", app.listPermissionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/permissions", app.requirePermission("users:admin", app.createPermissionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/audit", app.requirePermission("users:admin", app.listAuditEventsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/roles", app.requirePermission("users:admin", app.listRolesHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users", app.requirePermission("users:admin", app.listUsersHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id", app.requirePermission("users:admin", app.showUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/admin/users/:id", app.requirePermission("users:admin", app.updateUserHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/password-reset", app.requirePermission("users:admin", app.forcePasswordResetHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/tokens", app.requirePermission("users:admin", app.revokeUserTokensHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/permissions", app.requirePermission("users:admin", app.grantUserPermissionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/permissions/:code", app.requirePermission("users:admin", app.revokeUserPermissionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/roles", app.requirePermission("users:admin", app.grantUserRolesHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/roles/:role", app.requirePermission("users:admin", app.revokeUserRoleHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/impersonation", app.requirePermission("users:admin", app.forbidImpersonation(app.impersonateUserHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/lockout", app.requirePermission("users:admin", app.unlockUserHandler))
 
 	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
 
 	// 3 middleware
-	return app.metrics(app.recoveryPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	return app.metrics(app.requestID(app.recoveryPanic(app.enableCORS(app.rateLimit(app.authenticate(router))))))
 }
@@ -45,6 +45,7 @@ func (app *application) serve() error {
 			"addr": srv.Addr,
 		})
 
+		close(app.done)
 		app.wg.Wait()
 		shutdownError <- nil
 	}()
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"encoding/base64"
 	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/tomasen/realip"
 	"gl_api.malyshev.io/internal/data"
+	"gl_api.malyshev.io/internal/jwt"
+	"gl_api.malyshev.io/internal/totp"
 	"gl_api.malyshev.io/internal/validator"
 )
 
@@ -13,6 +18,7 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	var input struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		OTP      string `json:"otp"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -31,36 +37,440 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	ip := realip.FromRequest(r)
+
+	// экспоненциальная задержка после серии неудач - и по почте, и по IP
+	retryAfter, err := app.loginBackoff(input.Email, ip)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if retryAfter > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, retryAfter)
+		return
+	}
+
 	// собственно поиск пользовательской записи
 	user, err := app.models.Users.GetByEmail(input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.invalidCredentialsResponse(w, r)
+			app.loginFailed(w, r, nil, input.Email, ip, "unknown_email")
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
+	// пока аккаунт заблокирован, пароль не сравниваем вовсе: ответ всегда 401, как на неверный пароль,
+	// иначе перебор продолжался бы и во время блокировки, а отличающийся ответ выдал бы верный пароль.
+	// О блокировке владелец узнает из письма
+	// заблокированному администратором отвечаем так же, пароль не проверяем по той же причине
+	if user.Blocked {
+		app.loginFailed(w, r, user, input.Email, ip, "blocked")
+		return
+	}
+
+	_, err = app.models.LoginAttempts.LockedUntil(user.ID)
+	switch {
+	case err == nil:
+		app.loginFailed(w, r, user, input.Email, ip, "locked")
+		return
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	match, err := user.Password.Matches(input.Password)
-	if err != err {
+	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
 	if !match {
-		app.invalidCredentialsResponse(w, r)
+		app.loginFailed(w, r, user, input.Email, ip, "password")
+		return
+	}
+
+	if user.TOTPEnabled {
+		if input.OTP == "" {
+			app.otpRequiredResponse(w, r)
+			return
+		}
+
+		// код из приложения без ключа секретов не проверить, остаются только коды восстановления
+		if app.config.totp.key == nil && len(input.OTP) == totp.Digits {
+			app.totpUnavailableResponse(w, r)
+			return
+		}
+
+		ok, err := app.verifyOTP(user.ID, input.OTP, time.Now())
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !ok {
+			app.loginFailed(w, r, user, input.Email, ip, "otp")
+			return
+		}
+	}
+
+	err = app.models.LoginAttempts.ClearForEmail(input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// пароль известен только сейчас - заодно переводим хеш на актуальный алгоритм.
+	// Вход от этого не зависит, поэтому ошибки только логируем
+	if user.Password.NeedsRehash() {
+		err = app.rehashPassword(user, input.Password)
+		if err != nil {
+			app.logError(r, err)
+		}
+	}
+
+	env, err := app.newSessionTokens(r, user, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.auditAs(r, user.ID, data.AuditLogin, "user", user.ID, nil)
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// rehashPassword() сохраняем новый хеш пароля. Если запись успели изменить параллельно, пересчитаем при следующем входе
+func (app *application) rehashPassword(user *data.User, plaintext string) error {
+	err := user.Password.Set(plaintext)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil && !errors.Is(err, data.ErrEditConflict) {
+		return err
+	}
+
+	return nil
+}
+
+// newSessionTokens() выдаем пару токенов новой (family == nil) или продолжаемой сессии.
+// В режиме jwt вместо токена доступа отдаем подписанный JWT, а запись в tokens остается якорем сессии
+func (app *application) newSessionTokens(r *http.Request, user *data.User, family []byte) (envelope, error) {
+	token, refreshToken, err := app.models.Tokens.NewSession(user.ID, app.config.auth.accessTokenTTL, app.config.auth.refreshTokenTTL, family, realip.FromRequest(r), r.UserAgent())
+	if err != nil {
+		return nil, err
+	}
+
+	if app.config.auth.mode == "jwt" {
+		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		claims := jwt.Claims{
+			Subject:     strconv.FormatInt(user.ID, 10),
+			Session:     base64.RawURLEncoding.EncodeToString(token.Family),
+			Activated:   user.Activated,
+			Permissions: permissions,
+			IssuedAt:    time.Now().Unix(),
+			Expiry:      token.Expiry.Unix(),
+		}
+
+		token.Plaintext, err = app.signer.Sign(claims)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return envelope{"authentication_token": token, "refresh_token": refreshToken}, nil
+}
+
+// createActivationTokenHandler() повторная отправка письма активации, старые токены активации перестают действовать
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// ответ одинаковый в любом случае, чтобы не раскрывать есть ли такая почта в базе и активирована ли она
+	env := envelope{"message": "если для этой почты есть неактивированный аккаунт, на нее будет отправлено письмо активации"}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	// заблокированному письмо не шлем, ответ тот же, чтобы не раскрывать блокировку
+	if !user.Activated && !user.Blocked {
+		err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.audit(r, data.AuditTokenCreated, "user", user.ID, map[string]any{"scope": data.ScopeActivation})
+
+		app.background(func() {
+			data := map[string]interface{}{
+				"activationToken": token.Plaintext,
+			}
+
+			err := app.mailer.Send(user.Email, "token_activation.tmpl.html", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createPasswordResetTokenHandler() отправляет на почту одноразовый токен для сброса пароля
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// ответ одинаковый в любом случае, чтобы не раскрывать есть ли такая почта в базе
+	env := envelope{"message": "если аккаунт с такой почтой существует, на нее будет отправлена инструкция по сбросу пароля"}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// сбрасывать пароль можно только активированным аккаунтам
+	if user.Activated {
+		token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.audit(r, data.AuditTokenCreated, "user", user.ID, map[string]any{"scope": data.ScopePasswordReset})
+
+		app.background(func() {
+			data := map[string]interface{}{
+				"passwordResetToken": token.Plaintext,
+			}
+
+			err := app.mailer.Send(user.Email, "token_password_reset.tmpl.html", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAuthenticationTokenHandler() выход - отзываем токен, с которым пришел запрос
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	token := app.contextGetToken(r)
+
+	// JWT отозвать нельзя, он доживет свой короткий срок, но сессию за ним (токены обновления) отзываем
+	if app.signer != nil && jwt.Looks(token) {
+		claims, err := app.signer.Verify(token, time.Now())
+		if err != nil {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		family, err := base64.RawURLEncoding.DecodeString(claims.Session)
+		if err != nil || len(family) == 0 {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		err = app.models.Tokens.DeleteFamily(family)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.audit(r, data.AuditTokenRevoked, "user", app.contextGetUser(r).ID, map[string]any{"scope": data.ScopeAuthentication})
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"message": "токен аутентификации отозван"}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err := app.models.Tokens.Delete(data.ScopeAuthentication, token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, data.AuditTokenRevoked, "user", app.contextGetUser(r).ID, map[string]any{"scope": data.ScopeAuthentication})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "токен аутентификации отозван"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAllAuthenticationTokensHandler() выход со всех устройств - отзываем все токены пользователя
+func (app *application) deleteAllAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.models.Tokens.DeleteAllForUser(data.ScopeAuthentication, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeRefresh, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, data.AuditTokenRevoked, "user", user.ID, map[string]any{"scope": data.ScopeAuthentication, "all": true})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "все токены аутентификации отозваны"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// refreshAuthenticationTokenHandler() ротация: меняем токен обновления на новую пару токенов того же семейства
+func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	refreshToken, err := app.models.Tokens.UseRefreshToken(input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTokenReused):
+			app.logger.PrintInfo("повторное использование токена обновления, сессия отозвана", map[string]string{
+				"ip": realip.FromRequest(r),
+			})
+			app.audit(r, data.AuditTokenReused, "", 0, map[string]any{"scope": data.ScopeRefresh})
+			app.invalidAuthenticationTokenResponse(w, r)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// в claims попадают актуальные активация и права, поэтому пользователя берем из базы
+	user, err := app.models.Users.Get(refreshToken.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.Blocked {
+		err = app.models.Tokens.DeleteFamily(refreshToken.Family)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	env, err := app.newSessionTokens(r, user, refreshToken.Family)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.auditAs(r, user.ID, data.AuditTokenCreated, "user", user.ID, map[string]any{"scope": data.ScopeRefresh})
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
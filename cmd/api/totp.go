@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"gl_api.malyshev.io/internal/data"
+	"gl_api.malyshev.io/internal/totp"
+	"gl_api.malyshev.io/internal/validator"
+)
+
+// сколько кодов восстановления выдаем при включении 2FA
+const recoveryCodesCount = 10
+
+// verifyOTP() проверяем одноразовый код на момент now: код из приложения или код восстановления
+func (app *application) verifyOTP(userID int64, otp string, now time.Time) (bool, error) {
+	if len(otp) != totp.Digits {
+		return app.models.TOTP.UseRecoveryCode(userID, otp)
+	}
+
+	ciphertext, err := app.models.TOTP.GetSecret(userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	secret, err := totp.Decrypt(app.config.totp.key, ciphertext)
+	if err != nil {
+		return false, err
+	}
+
+	step, ok := totp.Validate(secret, otp, now)
+	if !ok {
+		return false, nil
+	}
+
+	// один и тот же код нельзя использовать дважды
+	return app.models.TOTP.UseStep(userID, step)
+}
+
+// enrollTOTPHandler() первый шаг подключения 2FA - выдаем секрет и otpauth ссылку для приложения
+func (app *application) enrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	// в контексте может быть неполный пользователь (из JWT), поэтому берем запись из базы
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(!user.TOTPEnabled, "totp", "двухфакторная аутентификация уже включена"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	ciphertext, err := totp.Encrypt(app.config.totp.key, secret)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.TOTP.SetSecret(user.ID, ciphertext)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"totp": map[string]string{
+			"secret": totp.EncodeSecret(secret),
+			"uri":    totp.URI(app.config.totp.issuer, user.Email, secret),
+		},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmTOTPHandler() второй шаг - пользователь подтверждает что приложение выдает верные коды,
+// включаем 2FA и один раз показываем коды восстановления
+func (app *application) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		OTP string `json:"otp"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.OTP != "", "otp", "должен быть задан")
+	v.Check(!user.TOTPEnabled, "totp", "двухфакторная аутентификация уже включена")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ok, err := app.verifyOTP(user.ID, input.OTP, time.Now())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		v.AddError("otp", "неверный или уже использованный код")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user.TOTPEnabled = true
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	codes, err := app.models.TOTP.NewRecoveryCodes(user.ID, recoveryCodesCount)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user, "recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// disableTOTPHandler() выключаем 2FA, нужен пароль и действующий одноразовый код
+func (app *application) disableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password string `json:"password"`
+		OTP      string `json:"otp"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidatePasswordPlaintext(v, input.Password)
+	v.Check(input.OTP != "", "otp", "должен быть задан")
+	v.Check(user.TOTPEnabled, "totp", "двухфакторная аутентификация не включена")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !match {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	ok, err := app.verifyOTP(user.ID, input.OTP, time.Now())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	user.TOTPEnabled = false
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.TOTP.Delete(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
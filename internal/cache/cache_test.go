@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+// clock подменяемые часы кеша
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func newTestCache(size int, ttl time.Duration) (*Cache[string, int], *clock) {
+	clk := &clock{t: time.Unix(1_700_000_000, 0)}
+
+	c := New[string, int](size, ttl)
+	c.now = clk.now
+
+	return c, clk
+}
+
+func keys(c *Cache[string, int]) []string {
+	var keys []string
+	for el := c.order.Front(); el != nil; el = el.Next() {
+		keys = append(keys, el.Value.(*entry[string, int]).key)
+	}
+	return keys
+}
+
+func TestEvictionOrder(t *testing.T) {
+	c, _ := newTestCache(3, time.Minute)
+
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Set("c", 3)
+
+	// обращение поднимает "a", самым старым становится "b"
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("Get(a) промах")
+	}
+
+	c.Set("d", 4)
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("b должен быть вытеснен как давно не использованный")
+	}
+
+	for _, key := range []string{"a", "c", "d"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("Get(%s) промах", key)
+		}
+	}
+
+	// перезапись существующего ключа тоже поднимает его
+	c.Set("a", 10)
+	c.Set("e", 5)
+
+	if _, ok := c.Get("c"); ok {
+		t.Error("c должен быть вытеснен после перезаписи a")
+	}
+
+	if v, ok := c.Get("a"); !ok || v != 10 {
+		t.Errorf("Get(a) = (%d, %t), want (10, true)", v, ok)
+	}
+}
+
+func TestSizeBound(t *testing.T) {
+	c, _ := newTestCache(5, time.Minute)
+
+	for i := 0; i < 100; i++ {
+		c.Set(string(rune('a'+i%26))+string(rune('a'+i/26)), i)
+
+		if got := c.Stats().Size; got > 5 {
+			t.Fatalf("после %d вставок размер %d > 5", i+1, got)
+		}
+	}
+
+	if got := len(c.items); got != 5 {
+		t.Errorf("len(items) = %d, want 5", got)
+	}
+
+	if got := len(keys(c)); got != 5 {
+		t.Errorf("длина списка = %d, want 5", got)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	c, clk := newTestCache(10, time.Minute)
+
+	c.Set("a", 1)
+	c.SetUntil("short", 2, clk.t.Add(10*time.Second))
+	c.SetUntil("long", 3, clk.t.Add(time.Hour))
+
+	clk.t = clk.t.Add(30 * time.Second)
+
+	if _, ok := c.Get("short"); ok {
+		t.Error("short должен истечь по своему expires")
+	}
+
+	if _, ok := c.Get("a"); !ok {
+		t.Error("a должен жить ttl")
+	}
+
+	clk.t = clk.t.Add(31 * time.Second)
+
+	if _, ok := c.Get("a"); ok {
+		t.Error("a должен истечь по ttl")
+	}
+
+	// expires дальше ttl обрезается до ttl
+	if _, ok := c.Get("long"); ok {
+		t.Error("long должен истечь по ttl, а не по своему expires")
+	}
+
+	if got := c.Stats().Size; got != 0 {
+		t.Errorf("просроченные записи не удалены, размер %d", got)
+	}
+}
+
+func TestDeleteFunc(t *testing.T) {
+	c, _ := newTestCache(10, time.Minute)
+
+	for i, key := range []string{"a", "b", "c", "d"} {
+		c.Set(key, i)
+	}
+
+	c.DeleteFunc(func(_ string, value int) bool {
+		return value%2 == 0
+	})
+
+	for key, want := range map[string]bool{"a": false, "b": true, "c": false, "d": true} {
+		if _, ok := c.Get(key); ok != want {
+			t.Errorf("Get(%s) ok = %t, want %t", key, ok, want)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	c, _ := newTestCache(10, time.Minute)
+
+	c.Set("a", 1)
+	c.Get("a")
+	c.Get("a")
+	c.Get("b")
+
+	stats := c.Stats()
+	if stats.Hits != 2 || stats.Misses != 1 || stats.Size != 1 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+
+	if stats.HitRatio < 0.66 || stats.HitRatio > 0.67 {
+		t.Errorf("HitRatio = %f, want 2/3", stats.HitRatio)
+	}
+}
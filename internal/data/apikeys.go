@@ -0,0 +1,264 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"gl_api.malyshev.io/internal/validator"
+)
+
+// APIKey долгоживущий именованный ключ для машинных клиентов, хранится в tokens со scope api-key
+type APIKey struct {
+	ID          int64       `json:"id"`
+	Plaintext   string      `json:"key,omitempty"` // отдаем только один раз при создании
+	Name        string      `json:"name"`
+	Permissions Permissions `json:"permissions"`
+	CreatedAt   time.Time   `json:"created_at"`
+	Expiry      *time.Time  `json:"expiry"` // nil - бессрочный ключ
+	LastUsed    *time.Time  `json:"last_used"`
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "поле должно быть заполнено")
+	v.Check(len(key.Name) <= 100, "name", "поле превышает 100 байт")
+
+	v.Check(key.Permissions != nil, "permissions", "поле должно быть задано")
+	v.Check(validator.Unique(key.Permissions), "permissions", "права должны быть уникальными")
+
+	if key.Expiry != nil {
+		v.Check(key.Expiry.After(time.Now()), "expiry", "срок действия должен быть в будущем")
+	}
+}
+
+// ValidateAPIKeyPermissions() ключ не может получить больше прав чем есть у владельца
+func ValidateAPIKeyPermissions(v *validator.Validator, key *APIKey, owner Permissions) {
+	for _, code := range key.Permissions {
+		v.Check(owner.Include(code), "permissions", "у владельца нет права "+code)
+	}
+}
+
+type APIKeyModel struct {
+	DB    *sql.DB
+	Cache *AuthCache
+}
+
+// New() генерируем и сохраняем новый ключ, открытое значение остается только в key.Plaintext
+func (m APIKeyModel) New(userID int64, key *APIKey) error {
+	token, err := generateToken(userID, 0, ScopeAPIKey)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO tokens (hash, user_id, expiry, scope, name, permissions)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at`
+
+	args := []interface{}{token.Hash, userID, key.Expiry, ScopeAPIKey, key.Name, pq.Array(key.Permissions)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	key.Plaintext = token.Plaintext
+
+	return nil
+}
+
+// GetAllForUser() все ключи пользователя, включая просроченные - чтобы их было видно и можно было удалить
+func (m APIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	query := `
+	SELECT id, name, permissions, created_at, expiry, last_used
+	FROM tokens
+	WHERE user_id = $1 AND scope = $2
+	ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, ScopeAPIKey)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		var key APIKey
+
+		err := rows.Scan(
+			&key.ID,
+			&key.Name,
+			pq.Array((*[]string)(&key.Permissions)),
+			&key.CreatedAt,
+			&key.Expiry,
+			&key.LastUsed,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, &key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Get() ключ пользователя по id
+func (m APIKeyModel) Get(userID, id int64) (*APIKey, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+	SELECT id, name, permissions, created_at, expiry, last_used
+	FROM tokens
+	WHERE id = $1 AND user_id = $2 AND scope = $3`
+
+	var key APIKey
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, userID, ScopeAPIKey).Scan(
+		&key.ID,
+		&key.Name,
+		pq.Array((*[]string)(&key.Permissions)),
+		&key.CreatedAt,
+		&key.Expiry,
+		&key.LastUsed,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &key, nil
+}
+
+// GetForPlaintext() ключ по его открытому значению, нужен middleware чтобы узнать права ключа
+func (m APIKeyModel) GetForPlaintext(keyPlaintext string) (*APIKey, error) {
+	keyHash := sha256.Sum256([]byte(keyPlaintext))
+
+	if key, ok := m.Cache.apiKey(keyHash); ok {
+		return key, nil
+	}
+
+	query := `
+	SELECT id, user_id, name, permissions, created_at, expiry, last_used
+	FROM tokens
+	WHERE hash = $1 AND scope = $2 AND (expiry IS NULL OR expiry > $3)`
+
+	var (
+		key    APIKey
+		userID int64
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, keyHash[:], ScopeAPIKey, time.Now()).Scan(
+		&key.ID,
+		&userID,
+		&key.Name,
+		pq.Array((*[]string)(&key.Permissions)),
+		&key.CreatedAt,
+		&key.Expiry,
+		&key.LastUsed,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	m.Cache.setAPIKey(keyHash, userID, &key)
+
+	return &key, nil
+}
+
+// Update() меняем имя, права и срок действия ключа
+func (m APIKeyModel) Update(userID int64, key *APIKey) error {
+	query := `
+	UPDATE tokens
+	SET name = $1, permissions = $2, expiry = $3
+	WHERE id = $4 AND user_id = $5 AND scope = $6`
+
+	args := []interface{}{key.Name, pq.Array(key.Permissions), key.Expiry, key.ID, userID, ScopeAPIKey}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	// могли измениться права и срок действия ключа
+	m.Cache.dropUser(userID)
+
+	return nil
+}
+
+// Delete() отзываем ключ пользователя
+func (m APIKeyModel) Delete(userID, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+	DELETE FROM tokens
+	WHERE id = $1 AND user_id = $2 AND scope = $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID, ScopeAPIKey)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	m.Cache.dropUser(userID)
+
+	return nil
+}
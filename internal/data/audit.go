@@ -0,0 +1,157 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// действия, которые попадают в журнал аудита
+const (
+	AuditLogin             = "auth.login"
+	AuditLoginFailed       = "auth.login_failed"
+	AuditAccountLocked     = "auth.account_locked"
+	AuditAccountUnlocked   = "auth.account_unlocked"
+	AuditTokenCreated      = "token.created"
+	AuditTokenRevoked      = "token.revoked"
+	AuditTokenReused       = "token.reused"
+	AuditUserActivated     = "user.activated"
+	AuditUserUpdated       = "user.updated"
+	AuditPermissionCreated = "permission.created"
+	AuditPermissionGranted = "permission.granted"
+	AuditPermissionRevoked = "permission.revoked"
+	AuditRoleGranted       = "role.granted"
+	AuditRoleRevoked       = "role.revoked"
+	AuditMovieCreated      = "movie.created"
+	AuditMovieUpdated      = "movie.updated"
+	AuditMovieDeleted      = "movie.deleted"
+)
+
+// AuditEvent запись журнала аудита. ActorID - от чьего имени сделано действие, nil - аноним
+type AuditEvent struct {
+	ID             int64          `json:"id"`
+	CreatedAt      time.Time      `json:"created_at"`
+	Action         string         `json:"action"`
+	ActorID        *int64         `json:"actor_id"`
+	ImpersonatorID *int64         `json:"impersonator_id,omitempty"`
+	TargetType     string         `json:"target_type,omitempty"`
+	TargetID       *int64         `json:"target_id,omitempty"`
+	IP             string         `json:"ip"`
+	RequestID      string         `json:"request_id"`
+	Details        map[string]any `json:"details,omitempty"`
+}
+
+// AuditFilter отбор записей журнала, пустые поля не ограничивают выборку
+type AuditFilter struct {
+	Action     string
+	ActorID    int64
+	TargetType string
+	TargetID   int64
+}
+
+type AuditModel struct {
+	DB *sql.DB
+}
+
+// Insert() журнал только пополняется, методов изменения и удаления нет намеренно
+func (m AuditModel) Insert(event *AuditEvent) error {
+	details := []byte("{}")
+
+	if event.Details != nil {
+		var err error
+
+		details, err = json.Marshal(event.Details)
+		if err != nil {
+			return err
+		}
+	}
+
+	query := `
+	INSERT INTO audit_events (action, actor_id, impersonator_id, target_type, target_id, ip, request_id, details)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	RETURNING id, created_at`
+
+	args := []interface{}{
+		event.Action,
+		event.ActorID,
+		event.ImpersonatorID,
+		event.TargetType,
+		event.TargetID,
+		event.IP,
+		event.RequestID,
+		details,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.CreatedAt)
+}
+
+// GetAll() страница журнала с отбором по действию, автору и объекту
+func (m AuditModel) GetAll(filter AuditFilter, filters Filters) ([]*AuditEvent, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, action, actor_id, impersonator_id, target_type, target_id, ip, request_id, details
+	FROM audit_events
+	WHERE (action = $1 OR $1 = '')
+	AND (actor_id = $2 OR $2 = 0)
+	AND (target_type = $3 OR $3 = '')
+	AND (target_id = $4 OR $4 = 0)
+	ORDER BY %s %s, id DESC
+	LIMIT $5 OFFSET $6`, filters.sortColumn(), filters.sortDirection())
+
+	args := []interface{}{filter.Action, filter.ActorID, filter.TargetType, filter.TargetID, filters.limit(), filters.offset()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	events := []*AuditEvent{}
+
+	for rows.Next() {
+		var (
+			event   AuditEvent
+			details []byte
+		)
+
+		err := rows.Scan(
+			&totalRecords,
+			&event.ID,
+			&event.CreatedAt,
+			&event.Action,
+			&event.ActorID,
+			&event.ImpersonatorID,
+			&event.TargetType,
+			&event.TargetID,
+			&event.IP,
+			&event.RequestID,
+			&details,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		err = json.Unmarshal(details, &event.Details)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return events, metadata, nil
+}
@@ -0,0 +1,189 @@
+package data
+
+import (
+	"slices"
+	"time"
+
+	"gl_api.malyshev.io/internal/cache"
+)
+
+// AuthCache кеш горячих запросов аутентификации: пользователь по токену и права пользователя.
+// Кеш живет в памяти процесса, поэтому изменения с других инстансов видны не позже чем через ttl.
+// nil - кеш выключен, все методы это учитывают
+type AuthCache struct {
+	users       *cache.Cache[tokenKey, cachedUser]
+	permissions *cache.Cache[int64, Permissions]
+	apiKeys     *cache.Cache[[32]byte, cachedAPIKey]
+	touches     *cache.Cache[tokenKey, struct{}]
+}
+
+type tokenKey struct {
+	scope string
+	hash  [32]byte
+}
+
+type cachedUser struct {
+	user           User
+	impersonatorID int64
+}
+
+// cachedAPIKey владелец нужен, чтобы сбросить ключ вместе с остальными токенами пользователя
+type cachedAPIKey struct {
+	key    APIKey
+	userID int64
+}
+
+// время последнего использования токена обновляем в базе не чаще раза в минуту
+const touchInterval = time.Minute
+
+// NewAuthCache() кеш до size записей каждого вида, со сроком жизни ttl
+func NewAuthCache(size int, ttl time.Duration) *AuthCache {
+	return &AuthCache{
+		users:       cache.New[tokenKey, cachedUser](size, ttl),
+		permissions: cache.New[int64, Permissions](size, ttl),
+		apiKeys:     cache.New[[32]byte, cachedAPIKey](size, ttl),
+		touches:     cache.New[tokenKey, struct{}](size, touchInterval),
+	}
+}
+
+// Stats() счетчики для expvar
+func (c *AuthCache) Stats() map[string]cache.Stats {
+	if c == nil {
+		return nil
+	}
+
+	return map[string]cache.Stats{
+		"users":       c.users.Stats(),
+		"permissions": c.permissions.Stats(),
+		"api_keys":    c.apiKeys.Stats(),
+	}
+}
+
+// кешируем только долгоживущие токены, одноразовые (активация, сброс пароля) смысла кешировать нет
+func cacheableScope(scope string) bool {
+	return scope == ScopeAuthentication || scope == ScopeAPIKey
+}
+
+// user() копия, чтобы обработчики не меняли закешированную запись
+func (c *AuthCache) user(scope string, hash [32]byte) (*User, int64, bool) {
+	if c == nil || !cacheableScope(scope) {
+		return nil, 0, false
+	}
+
+	cached, ok := c.users.Get(tokenKey{scope, hash})
+	if !ok {
+		return nil, 0, false
+	}
+
+	user := cached.user
+	return &user, cached.impersonatorID, true
+}
+
+// setUser() запись живет не дольше самого токена
+func (c *AuthCache) setUser(scope string, hash [32]byte, user *User, impersonatorID int64, expiry *time.Time) {
+	if c == nil || !cacheableScope(scope) {
+		return
+	}
+
+	var expires time.Time
+	if expiry != nil {
+		expires = *expiry
+	}
+
+	c.users.SetUntil(tokenKey{scope, hash}, cachedUser{*user, impersonatorID}, expires)
+}
+
+// apiKey() копия ключа с его правами
+func (c *AuthCache) apiKey(hash [32]byte) (*APIKey, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	cached, ok := c.apiKeys.Get(hash)
+	if !ok {
+		return nil, false
+	}
+
+	key := cached.key
+	key.Permissions = slices.Clone(key.Permissions)
+	return &key, true
+}
+
+// setAPIKey() запись живет не дольше самого ключа
+func (c *AuthCache) setAPIKey(hash [32]byte, userID int64, key *APIKey) {
+	if c == nil {
+		return
+	}
+
+	var expires time.Time
+	if key.Expiry != nil {
+		expires = *key.Expiry
+	}
+
+	cached := cachedAPIKey{*key, userID}
+	cached.key.Permissions = slices.Clone(key.Permissions)
+
+	c.apiKeys.SetUntil(hash, cached, expires)
+}
+
+func (c *AuthCache) permissionsFor(userID int64) (Permissions, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	permissions, ok := c.permissions.Get(userID)
+	if !ok {
+		return nil, false
+	}
+
+	return slices.Clone(permissions), true
+}
+
+func (c *AuthCache) setPermissions(userID int64, permissions Permissions) {
+	if c == nil {
+		return
+	}
+
+	c.permissions.Set(userID, slices.Clone(permissions))
+}
+
+// touch() true - пора обновить время последнего использования в базе
+func (c *AuthCache) touch(scope string, hash [32]byte) bool {
+	if c == nil {
+		return true
+	}
+
+	key := tokenKey{scope, hash}
+
+	if _, ok := c.touches.Get(key); ok {
+		return false
+	}
+
+	c.touches.Set(key, struct{}{})
+	return true
+}
+
+// dropUser() забываем все токены и API ключи пользователя, включая выданные им для входа от имени других:
+// изменилась его запись или отозваны токены
+func (c *AuthCache) dropUser(userID int64) {
+	if c == nil {
+		return
+	}
+
+	c.users.DeleteFunc(func(_ tokenKey, cached cachedUser) bool {
+		return cached.user.ID == userID || cached.impersonatorID == userID
+	})
+
+	c.apiKeys.DeleteFunc(func(_ [32]byte, cached cachedAPIKey) bool {
+		return cached.userID == userID
+	})
+}
+
+// dropPermissions() права пользователя изменились
+func (c *AuthCache) dropPermissions(userID int64) {
+	if c == nil {
+		return
+	}
+
+	c.permissions.Delete(userID)
+}
@@ -0,0 +1,120 @@
+package data
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+)
+
+func TestAuthCacheDropUser(t *testing.T) {
+	c := NewAuthCache(100, time.Minute)
+
+	alice, bob := &User{ID: 1}, &User{ID: 2}
+
+	aliceToken := sha256.Sum256([]byte("alice"))
+	bobToken := sha256.Sum256([]byte("bob"))
+	impersonation := sha256.Sum256([]byte("alice as bob"))
+	aliceKey := sha256.Sum256([]byte("alice key"))
+	bobKey := sha256.Sum256([]byte("bob key"))
+
+	c.setUser(ScopeAuthentication, aliceToken, alice, 0, nil)
+	c.setUser(ScopeAuthentication, bobToken, bob, 0, nil)
+	c.setUser(ScopeAuthentication, impersonation, bob, alice.ID, nil)
+	c.setAPIKey(aliceKey, alice.ID, &APIKey{ID: 10, Permissions: Permissions{"movies:read"}})
+	c.setAPIKey(bobKey, bob.ID, &APIKey{ID: 11, Permissions: Permissions{"movies:read"}})
+
+	c.dropUser(alice.ID)
+
+	if _, _, ok := c.user(ScopeAuthentication, aliceToken); ok {
+		t.Error("токен alice остался в кеше")
+	}
+
+	if _, _, ok := c.user(ScopeAuthentication, impersonation); ok {
+		t.Error("вход alice от имени bob остался в кеше")
+	}
+
+	if _, ok := c.apiKey(aliceKey); ok {
+		t.Error("API ключ alice остался в кеше")
+	}
+
+	if user, _, ok := c.user(ScopeAuthentication, bobToken); !ok || user.ID != bob.ID {
+		t.Error("токен bob не должен сбрасываться")
+	}
+
+	if _, ok := c.apiKey(bobKey); !ok {
+		t.Error("API ключ bob не должен сбрасываться")
+	}
+}
+
+func TestAuthCacheDropPermissions(t *testing.T) {
+	c := NewAuthCache(100, time.Minute)
+
+	c.setPermissions(1, Permissions{"movies:read"})
+	c.setPermissions(2, Permissions{"movies:write"})
+
+	c.dropPermissions(1)
+
+	if _, ok := c.permissionsFor(1); ok {
+		t.Error("права пользователя 1 остались в кеше")
+	}
+
+	if permissions, ok := c.permissionsFor(2); !ok || !permissions.Include("movies:write") {
+		t.Error("права пользователя 2 не должны сбрасываться")
+	}
+}
+
+func TestAuthCacheCopies(t *testing.T) {
+	c := NewAuthCache(100, time.Minute)
+
+	hash := sha256.Sum256([]byte("key"))
+	c.setAPIKey(hash, 1, &APIKey{ID: 10, Permissions: Permissions{"movies:read"}})
+
+	key, _ := c.apiKey(hash)
+	key.Permissions[0] = "*:*"
+
+	if again, _ := c.apiKey(hash); again.Permissions[0] != "movies:read" {
+		t.Error("изменение отданного ключа попало в кеш")
+	}
+
+	c.setPermissions(1, Permissions{"movies:read"})
+
+	permissions, _ := c.permissionsFor(1)
+	permissions[0] = "*:*"
+
+	if again, _ := c.permissionsFor(1); again[0] != "movies:read" {
+		t.Error("изменение отданных прав попало в кеш")
+	}
+}
+
+func TestAuthCacheExpiry(t *testing.T) {
+	c := NewAuthCache(100, time.Minute)
+
+	hash := sha256.Sum256([]byte("key"))
+	expired := time.Now().Add(-time.Second)
+
+	c.setAPIKey(hash, 1, &APIKey{ID: 10, Expiry: &expired})
+
+	if _, ok := c.apiKey(hash); ok {
+		t.Error("истекший ключ отдан из кеша")
+	}
+}
+
+func TestAuthCacheDisabled(t *testing.T) {
+	var c *AuthCache
+
+	hash := sha256.Sum256([]byte("key"))
+
+	c.setUser(ScopeAuthentication, hash, &User{ID: 1}, 0, nil)
+	c.setAPIKey(hash, 1, &APIKey{})
+	c.setPermissions(1, Permissions{"movies:read"})
+	c.dropUser(1)
+	c.dropPermissions(1)
+
+	if _, _, ok := c.user(ScopeAuthentication, hash); ok {
+		t.Error("выключенный кеш отдал пользователя")
+	}
+
+	if !c.touch(ScopeAuthentication, hash) {
+		t.Error("без кеша время использования обновляется всегда")
+	}
+}
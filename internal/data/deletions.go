@@ -0,0 +1,117 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// AccountDeletionModel запланированные удаления аккаунтов, до delete_after удаление можно отменить
+type AccountDeletionModel struct {
+	DB    *sql.DB
+	Cache *AuthCache
+}
+
+// Schedule() планируем удаление аккаунта на момент after
+func (m AccountDeletionModel) Schedule(userID int64, after time.Time) error {
+	query := `
+	INSERT INTO account_deletions (user_id, delete_after)
+	VALUES ($1, $2)
+	ON CONFLICT (user_id) DO UPDATE SET delete_after = EXCLUDED.delete_after, created_at = NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, after)
+	return err
+}
+
+// Get() когда аккаунт будет удален, ErrRecordNotFound - удаление не запланировано
+func (m AccountDeletionModel) Get(userID int64) (time.Time, error) {
+	query := `
+	SELECT delete_after
+	FROM account_deletions
+	WHERE user_id = $1`
+
+	var deleteAfter time.Time
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&deleteAfter)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return time.Time{}, ErrRecordNotFound
+		default:
+			return time.Time{}, err
+		}
+	}
+
+	return deleteAfter, nil
+}
+
+// Cancel() отменяем запланированное удаление
+func (m AccountDeletionModel) Cancel(userID int64) error {
+	query := `
+	DELETE FROM account_deletions
+	WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteDue() удаляем аккаунты, у которых истек срок отмены. Токены и права чистит ON DELETE CASCADE
+func (m AccountDeletionModel) DeleteDue() (int64, error) {
+	query := `
+	DELETE FROM users
+	WHERE id IN (SELECT user_id FROM account_deletions WHERE delete_after <= $1)
+	RETURNING id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var deleted int64
+
+	for rows.Next() {
+		var userID int64
+
+		err := rows.Scan(&userID)
+		if err != nil {
+			return 0, err
+		}
+
+		m.Cache.dropUser(userID)
+		m.Cache.dropPermissions(userID)
+		deleted++
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
+}
@@ -1,7 +1,11 @@
 package data
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"math"
+	"strconv"
 	"strings"
 
 	"gl_api.malyshev.io/internal/validator"
@@ -13,6 +17,11 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	// After и Before курсоры постраничной навигации по ключу вместо номера страницы
+	After  string
+	Before string
+	// SkipTotal не считать общее количество записей, на больших выборках это дорого
+	SkipTotal bool
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
@@ -22,6 +31,62 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize <= 100, "page_size", "не должно превышать 10 млн.")
 
 	v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "неверное значение сортировки")
+
+	v.Check(f.After == "" || f.Before == "", "after", "нельзя задавать after и before одновременно")
+	v.Check(f.Page == 1 || !f.cursorMode(), "page", "не используется вместе с курсором")
+
+	if f.After != "" {
+		v.Check(validCursor(f.After, f.Sort), "after", "некорректный курсор")
+	}
+
+	if f.Before != "" {
+		v.Check(validCursor(f.Before, f.Sort), "before", "некорректный курсор")
+	}
+}
+
+// validCursor() курсор привязан к сортировке, с которой он выдан, и значение в нем должно подходить
+// по типу колонке сортировки, иначе сравнение с ней упадет уже в базе
+func validCursor(raw, sort string) bool {
+	c, err := decodeCursor(raw)
+	if err != nil || c.Sort != sort {
+		return false
+	}
+
+	if strings.TrimPrefix(sort, "-") == "title" {
+		return true
+	}
+
+	_, err = strconv.ParseInt(c.Value, 10, 64)
+	return err == nil
+}
+
+func (f Filters) cursorMode() bool {
+	return f.After != "" || f.Before != ""
+}
+
+// cursor позиция в выборке: значение ключа сортировки и id последней (первой) показанной записи.
+// Клиенту отдается непрозрачной base64 строкой
+type cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int64  `json:"i"`
+}
+
+func encodeCursor(c cursor) string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+
+	err = json.Unmarshal(js, &c)
+	return c, err
 }
 
 func (f Filters) sortColumn() string {
@@ -49,12 +114,44 @@ func (f Filters) offset() int {
 	return (f.Page - 1) * f.PageSize
 }
 
+// where условия выборки с нумерованными плейсхолдерами: незаданный фильтр просто не попадает в запрос,
+// вместо проверки "параметр пустой" на каждый фильтр прямо в SQL
+type where struct {
+	conds []string
+	args  []interface{}
+}
+
+// arg() добавляет значение в аргументы запроса и возвращает его плейсхолдер
+func (w *where) arg(value interface{}) string {
+	w.args = append(w.args, value)
+	return "$" + strconv.Itoa(len(w.args))
+}
+
+// add() добавляет условие, %s (или %[n]s) в cond заменяются плейсхолдерами values по порядку
+func (w *where) add(cond string, values ...interface{}) {
+	placeholders := make([]interface{}, len(values))
+	for i, value := range values {
+		placeholders[i] = w.arg(value)
+	}
+
+	w.conds = append(w.conds, fmt.Sprintf(cond, placeholders...))
+}
+
+func (w *where) String() string {
+	if len(w.conds) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(w.conds, " AND ")
+}
+
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
 }
 
 func calculateMetadata(totalRecords, page, PageSize int) Metadata {
@@ -0,0 +1,53 @@
+package data
+
+import (
+	"testing"
+
+	"gl_api.malyshev.io/internal/validator"
+)
+
+func TestValidateFiltersCursor(t *testing.T) {
+	safelist := []string{"id", "title", "year", "-id", "-title", "-year"}
+
+	tests := []struct {
+		name   string
+		sort   string
+		cursor cursor
+		valid  bool
+	}{
+		{"numeric", "-year", cursor{Sort: "-year", Value: "2001", ID: 12}, true},
+		{"id", "id", cursor{Sort: "id", Value: "12", ID: 12}, true},
+		{"text", "title", cursor{Sort: "title", Value: "Godzilla", ID: 3}, true},
+		{"text value for numeric column", "year", cursor{Sort: "year", Value: "abc", ID: 1}, false},
+		{"empty value for numeric column", "-year", cursor{Sort: "-year", Value: "", ID: 1}, false},
+		{"other sort", "year", cursor{Sort: "-year", Value: "2001", ID: 1}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+
+			ValidateFilters(v, Filters{
+				Page:         1,
+				PageSize:     20,
+				Sort:         tt.sort,
+				SortSafelist: safelist,
+				After:        encodeCursor(tt.cursor),
+			})
+
+			if v.Valid() != tt.valid {
+				t.Errorf("ValidateFilters() valid = %t, want %t, errors %v", v.Valid(), tt.valid, v.Errors)
+			}
+		})
+	}
+
+	t.Run("not base64", func(t *testing.T) {
+		v := validator.New()
+
+		ValidateFilters(v, Filters{Page: 1, PageSize: 20, Sort: "id", SortSafelist: safelist, Before: "!!!"})
+
+		if v.Valid() {
+			t.Error("ValidateFilters() принял мусорный курсор")
+		}
+	})
+}
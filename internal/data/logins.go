@@ -0,0 +1,148 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// LoginFailures неудачные попытки входа за окно наблюдения, отдельно по почте и по IP
+type LoginFailures struct {
+	ByEmail     int
+	ByIP        int
+	LastByEmail time.Time
+	LastByIP    time.Time
+}
+
+// LoginAttemptModel учет неудачных попыток входа и блокировок аккаунтов
+type LoginAttemptModel struct {
+	DB *sql.DB
+}
+
+// RecordFailure() запоминаем неудачную попытку, заодно подчищаем старые записи этой почты и IP
+func (m LoginAttemptModel) RecordFailure(email, ip string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+	DELETE FROM login_attempts
+	WHERE (email = $1 OR ip = $2) AND created_at < $3`
+
+	_, err := m.DB.ExecContext(ctx, query, email, ip, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		return err
+	}
+
+	query = `
+	INSERT INTO login_attempts (email, ip)
+	VALUES ($1, $2)`
+
+	_, err = m.DB.ExecContext(ctx, query, email, ip)
+	return err
+}
+
+// GetFailures() неудачные попытки начиная с since
+func (m LoginAttemptModel) GetFailures(email, ip string, since time.Time) (LoginFailures, error) {
+	query := `
+	SELECT
+		count(*) FILTER (WHERE email = $1),
+		count(*) FILTER (WHERE ip = $2),
+		COALESCE(max(created_at) FILTER (WHERE email = $1), 'epoch'),
+		COALESCE(max(created_at) FILTER (WHERE ip = $2), 'epoch')
+	FROM login_attempts
+	WHERE (email = $1 OR ip = $2) AND created_at >= $3`
+
+	var failures LoginFailures
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, email, ip, since).Scan(
+		&failures.ByEmail,
+		&failures.ByIP,
+		&failures.LastByEmail,
+		&failures.LastByIP,
+	)
+
+	return failures, err
+}
+
+// ClearForEmail() после успешного входа счетчик по почте обнуляется
+func (m LoginAttemptModel) ClearForEmail(email string) error {
+	query := `
+	DELETE FROM login_attempts
+	WHERE email = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, email)
+	return err
+}
+
+// Lock() временно блокируем аккаунт
+func (m LoginAttemptModel) Lock(userID int64, until time.Time) error {
+	query := `
+	INSERT INTO users_lockouts (user_id, locked_until)
+	VALUES ($1, $2)
+	ON CONFLICT (user_id) DO UPDATE SET locked_until = EXCLUDED.locked_until, created_at = NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, until)
+	return err
+}
+
+// LockedUntil() до какого момента аккаунт заблокирован, ErrRecordNotFound - блокировки нет
+func (m LoginAttemptModel) LockedUntil(userID int64) (time.Time, error) {
+	query := `
+	SELECT locked_until
+	FROM users_lockouts
+	WHERE user_id = $1 AND locked_until > $2`
+
+	var lockedUntil time.Time
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, time.Now()).Scan(&lockedUntil)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return time.Time{}, ErrRecordNotFound
+		default:
+			return time.Time{}, err
+		}
+	}
+
+	return lockedUntil, nil
+}
+
+// Unlock() снимаем блокировку и сбрасываем счетчик попыток по почте пользователя
+func (m LoginAttemptModel) Unlock(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM users_lockouts WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+	DELETE FROM login_attempts
+	WHERE email = (SELECT email FROM users WHERE id = $1)`
+
+	_, err = m.DB.ExecContext(ctx, query, userID)
+	return err
+}
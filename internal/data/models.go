@@ -11,18 +11,31 @@ var (
 )
 
 type Models struct {
-	Movies      MovieModel
-	Permissions PermissionModel
-	Users       UserModel
-	Tokens      TokenModel
+	APIKeys       APIKeyModel
+	Audit         AuditModel
+	Deletions     AccountDeletionModel
+	Movies        MovieModel
+	LoginAttempts LoginAttemptModel
+	Permissions   PermissionModel
+	Roles         RoleModel
+	Users         UserModel
+	Tokens        TokenModel
+	TOTP          TOTPModel
 }
 
-func NewModels(db *sql.DB) Models {
+// NewModels() cache может быть nil, тогда кеш аутентификации выключен
+func NewModels(db *sql.DB, cache *AuthCache) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Permissions: PermissionModel{DB: db},
-		Users:       UserModel{DB: db},
-		Tokens:      TokenModel{DB: db},
+		APIKeys:       APIKeyModel{DB: db, Cache: cache},
+		Audit:         AuditModel{DB: db},
+		Deletions:     AccountDeletionModel{DB: db, Cache: cache},
+		Movies:        MovieModel{DB: db},
+		LoginAttempts: LoginAttemptModel{DB: db},
+		Permissions:   PermissionModel{DB: db, Cache: cache},
+		Roles:         RoleModel{DB: db, Cache: cache},
+		Users:         UserModel{DB: db, Cache: cache},
+		Tokens:        TokenModel{DB: db, Cache: cache},
+		TOTP:          TOTPModel{DB: db},
 	}
 }
 
@@ -5,7 +5,11 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/lib/pq"
 	"gl_api.malyshev.io/internal/validator"
@@ -18,9 +22,41 @@ type Movie struct {
 	Year      int32     `json:"year,omitempty"`
 	Runtime   Runtime   `json:"runtime,omitempty,string"`
 	Genres    []string  `json:"genres,omitempty"`
+	Language  string    `json:"language,omitempty"`   // конфигурация полнотекстового поиска для названия, см. Languages
+	CreatedBy *int64    `json:"created_by,omitempty"` // владелец, nil - фильм заведен до появления владельцев или автор удален
 	Version   int32     `json:"version"`
 }
 
+// MovieEditor пользователь, которому владелец дал право редактировать фильм
+type MovieEditor struct {
+	UserID    int64     `json:"user_id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Languages языки названий, для которых есть конфигурация полнотекстового поиска postgres.
+// simple - без стемминга, для названий, язык которых не определить
+var Languages = []string{"simple", "english", "russian"}
+
+// DetectLanguage() язык по алфавиту названия, если клиент его не указал
+func DetectLanguage(title string) string {
+	latin := false
+
+	for _, r := range title {
+		if unicode.Is(unicode.Cyrillic, r) {
+			return "russian"
+		}
+		if r <= unicode.MaxASCII && unicode.IsLetter(r) {
+			latin = true
+		}
+	}
+
+	if latin {
+		return "english"
+	}
+	return "simple"
+}
+
 func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(movie.Title != "", "title", "Не может быть пустым")
 	v.Check(len(movie.Title) <= 500, "title", "Должно быть меньше 500 байт")
@@ -37,6 +73,8 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 
 	v.Check(movie.Runtime != 0, "runtime", "Не может быть пустым")
 	v.Check(movie.Runtime > 0, "runtime", "Должно быть положительным числом")
+
+	v.Check(validator.In(movie.Language, Languages...), "language", "неподдерживаемый язык")
 }
 
 type MovieModel struct {
@@ -46,13 +84,13 @@ type MovieModel struct {
 // Insert method to movie DB
 func (m MovieModel) Insert(movie *Movie) error {
 	query := `
-		INSERT INTO movies (title, year, runtime, genres)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO movies (title, year, runtime, genres, language, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
 		RETURNING id, created_at, version
 	`
 
 	// TODO pattern to snippet storage
-	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.Language, movie.CreatedBy}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -67,7 +105,7 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	}
 
 	query := `
-		SELECT id, created_at, title, year, runtime, genres, version
+		SELECT id, created_at, title, year, runtime, genres, language::text, created_by, version
 		FROM movies
 		WHERE id = $1
 	`
@@ -84,6 +122,8 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		&movie.Year,
 		&movie.Runtime,
 		pq.Array(&movie.Genres),
+		&movie.Language,
+		&movie.CreatedBy,
 		&movie.Version,
 	)
 
@@ -103,8 +143,8 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 func (m MovieModel) Update(movie *Movie) error {
 	query := `
 		UPDATE movies
-		SET title=$1, year=$2, runtime=$3, genres=$4, version=version+1
-		WHERE id = $5 AND version = $6
+		SET title=$1, year=$2, runtime=$3, genres=$4, language=$5, version=version+1
+		WHERE id = $6 AND version = $7
 		RETURNING version
 	`
 	args := []interface{}{
@@ -112,6 +152,7 @@ func (m MovieModel) Update(movie *Movie) error {
 		movie.Year,
 		movie.Runtime,
 		pq.Array(movie.Genres),
+		movie.Language,
 		movie.ID,
 		movie.Version,
 	}
@@ -162,15 +203,191 @@ func (m MovieModel) Delete(id int64) error {
 	return nil
 }
 
-// GetAll() отдаем данные по нескольким фильмам применяем фильтры и сортировку
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+// режимы сопоставления жанров в MovieFilters.GenresMode
+const (
+	GenresAll = "all" // фильм содержит все перечисленные жанры
+	GenresAny = "any" // фильм содержит хотя бы один из жанров
+)
+
+// режимы поиска по названию в MovieFilters.Search
+const (
+	SearchFTS    = "fts"    // полнотекстовый по целым словам
+	SearchPrefix = "prefix" // слова запроса как начала слов названия: "godz" находит "Godzilla"
+	SearchFuzzy  = "fuzzy"  // с опечатками по триграммам pg_trgm
+)
+
+// SortRelevance сортировка по релевантности поиска по названию
+const SortRelevance = "relevance"
+
+// MovieFilters условия выборки фильмов, нулевое значение поля - фильтр не задан
+type MovieFilters struct {
+	Title  string
+	Search string
+	// Language конфигурация для поискового запроса, пусто - 'simple' без стемминга
+	Language   string
+	Genres     []string
+	GenresMode string
+	YearMin    int
+	YearMax    int
+	RuntimeMin int
+	RuntimeMax int
+	// CreatedAfter включительно, CreatedBefore - нет
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
+
+func ValidateMovieFilters(v *validator.Validator, f MovieFilters) {
+	v.Check(validator.In(f.Search, SearchFTS, SearchPrefix, SearchFuzzy), "search", "должно быть fts, prefix или fuzzy")
+	v.Check(f.Language == "" || validator.In(f.Language, Languages...), "lang", "неподдерживаемый язык")
+	v.Check(validator.In(f.GenresMode, GenresAll, GenresAny), "genres_mode", "должно быть all или any")
+
+	v.Check(f.YearMin >= 0, "year_min", "не может быть отрицательным")
+	v.Check(f.YearMax >= 0, "year_max", "не может быть отрицательным")
+	v.Check(f.YearMin == 0 || f.YearMax == 0 || f.YearMin <= f.YearMax, "year_max", "должно быть не меньше year_min")
+
+	v.Check(f.RuntimeMin >= 0, "runtime_min", "не может быть отрицательным")
+	v.Check(f.RuntimeMax >= 0, "runtime_max", "не может быть отрицательным")
+	v.Check(f.RuntimeMin == 0 || f.RuntimeMax == 0 || f.RuntimeMin <= f.RuntimeMax, "runtime_max", "должно быть не меньше runtime_min")
+
+	v.Check(f.CreatedAfter.IsZero() || f.CreatedBefore.IsZero() || f.CreatedAfter.Before(f.CreatedBefore), "created_before", "должно быть позже created_after")
+}
+
+// where() условия WHERE только по заданным фильтрам и выражение релевантности для поиска по названию
+func (f MovieFilters) where() (*where, string) {
+	w := &where{}
+	rank := ""
+
+	if f.Title != "" {
+		// без языка ищем по индексу на to_tsvector('simple', title), с языком - по индексу на to_tsvector(language, title)
+		config, vector := "'simple'", "to_tsvector('simple', title)"
+		if f.Language != "" && f.Search != SearchFuzzy {
+			config, vector = w.arg(f.Language)+"::regconfig", "to_tsvector(language, title)"
+		}
+
+		switch query := prefixQuery(f.Title); {
+		case f.Search == SearchFuzzy:
+			// word_similarity сравнивает запрос с наиболее похожим куском названия, а не со всей строкой
+			p := w.arg(f.Title)
+			w.conds = append(w.conds, p+" <% title")
+			rank = "word_similarity(" + p + ", title)"
+		case f.Search == SearchPrefix && query != "":
+			p := w.arg(query)
+			w.conds = append(w.conds, vector+" @@ to_tsquery("+config+", "+p+")")
+			rank = "ts_rank(" + vector + ", to_tsquery(" + config + ", " + p + "))"
+		default:
+			p := w.arg(f.Title)
+			w.conds = append(w.conds, vector+" @@ plainto_tsquery("+config+", "+p+")")
+			rank = "ts_rank(" + vector + ", plainto_tsquery(" + config + ", " + p + "))"
+		}
+	}
+
+	if len(f.Genres) > 0 {
+		if f.GenresMode == GenresAny {
+			w.add("genres && %s", pq.Array(f.Genres))
+		} else {
+			w.add("genres @> %s", pq.Array(f.Genres))
+		}
+	}
+
+	if f.YearMin > 0 {
+		w.add("year >= %s", f.YearMin)
+	}
+	if f.YearMax > 0 {
+		w.add("year <= %s", f.YearMax)
+	}
+
+	if f.RuntimeMin > 0 {
+		w.add("runtime >= %s", f.RuntimeMin)
+	}
+	if f.RuntimeMax > 0 {
+		w.add("runtime <= %s", f.RuntimeMax)
+	}
+
+	if !f.CreatedAfter.IsZero() {
+		w.add("created_at >= %s", f.CreatedAfter)
+	}
+	if !f.CreatedBefore.IsZero() {
+		w.add("created_at < %s", f.CreatedBefore)
+	}
+
+	return w, rank
+}
+
+// prefixQuery() tsquery из слов запроса, каждое как префикс: "godz kin" -> "godz:* & kin:*".
+// Все кроме букв и цифр выбрасываем, чтобы пользователь не мог подсунуть операторы tsquery
+func prefixQuery(title string) string {
+	words := strings.FieldsFunc(title, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for i := range words {
+		words[i] += ":*"
+	}
+
+	return strings.Join(words, " & ")
+}
+
+// GetAll() отдаем данные по нескольким фильмам применяем фильтры и сортировку.
+// Страницы выбираются либо по номеру (LIMIT/OFFSET), либо по курсору filters.After/Before (по ключу сортировки)
+func (m MovieModel) GetAll(movieFilters MovieFilters, filters Filters) ([]*Movie, Metadata, error) {
+	column, direction := filters.sortColumn(), filters.sortDirection()
+
+	w, rank := movieFilters.where()
+
+	// релевантность - выражение от запроса, а не колонка; без поиска по названию сортируем по id
+	if column == SortRelevance {
+		column, direction = rank, "DESC"
+		if rank == "" {
+			column, direction = "id", "ASC"
+		}
+	}
+
+	total := "count(*) OVER()"
+	if filters.SkipTotal || filters.cursorMode() {
+		total = "0"
+	}
+
+	offset := filters.offset()
+
+	order := fmt.Sprintf("%s %s, id ASC", column, direction)
+
+	backward := filters.Before != ""
+
+	if filters.cursorMode() {
+		raw := filters.After
+		if backward {
+			raw = filters.Before
+		}
+
+		c, err := decodeCursor(raw)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		// строки после курсора в порядке сортировки, для before - до курсора, выбираем их в обратном порядке
+		op, idOp := ">", ">"
+		if direction == "DESC" {
+			op = "<"
+		}
+
+		if backward {
+			op, idOp = invert(op), invert(idOp)
+			order = fmt.Sprintf("%s %s, id DESC", column, invertDirection(direction))
+		}
+
+		w.add(fmt.Sprintf("(%[1]s %[2]s %%[1]s OR (%[1]s = %%[1]s AND id %[3]s %%[2]s))", column, op, idOp), c.Value, c.ID)
+		offset = 0
+	}
+
+	conditions := w.String()
+	limitArg, offsetArg := w.arg(filters.limit()+1), w.arg(offset)
+
 	query := fmt.Sprintf(`
-		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
+		SELECT %s, id, created_at, title, year, runtime, genres, language::text, created_by, version
 		FROM movies
-		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-		AND (genres @> $2 OR $2 = '{}')
-		ORDER BY %s %s, id ASC
-		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+		%s
+		ORDER BY %s
+		LIMIT %s OFFSET %s`, total, conditions, order, limitArg, offsetArg)
 	// Вариант 2 но (The club === Panther ==='THE')
 	// WHERE (STRPOS(LOWER(title), LOWER($1)) > 0 OR $1 = '')
 	// Вариант 3 но если мы хотим искать и ссуффиксами напримел 's или пробелом нужно будет или добавлять в запрос % или уточнять подстановку
@@ -179,9 +396,7 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []interface{}{title, pq.Array(genres), filters.limit(), filters.offset()}
-
-	rows, err := m.DB.QueryContext(ctx, query, args...)
+	rows, err := m.DB.QueryContext(ctx, query, w.args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -202,6 +417,8 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 			&movie.Year,
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
+			&movie.Language,
+			&movie.CreatedBy,
 			&movie.Version,
 		)
 
@@ -216,10 +433,317 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	// лишняя запись говорит о том, что дальше (для before - раньше) есть еще строки
+	more := len(movies) > filters.limit()
+	if more {
+		movies = movies[:filters.limit()]
+	}
+
+	if backward {
+		slices.Reverse(movies)
+	}
+
+	var metadata Metadata
+
+	switch {
+	case !filters.cursorMode() && !filters.SkipTotal:
+		metadata = calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	case !filters.cursorMode():
+		metadata = Metadata{CurrentPage: filters.Page, PageSize: filters.PageSize, FirstPage: 1}
+	case !filters.SkipTotal:
+		totalRecords, err = m.count(ctx, movieFilters)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		metadata = Metadata{PageSize: filters.PageSize, TotalRecords: totalRecords}
+	default:
+		metadata = Metadata{PageSize: filters.PageSize}
+	}
+
+	// релевантность не колонка, по ней курсор не построить - только номера страниц
+	if len(movies) > 0 && filters.Sort != SortRelevance {
+		first, last := movies[0], movies[len(movies)-1]
+
+		next := encodeCursor(movieCursor(filters.Sort, column, last))
+		prev := encodeCursor(movieCursor(filters.Sort, column, first))
+
+		// при движении назад следующая страница есть всегда - с нее мы и пришли
+		if backward {
+			metadata.NextCursor = next
+			if more {
+				metadata.PrevCursor = prev
+			}
+		} else {
+			if more {
+				metadata.NextCursor = next
+			}
+			if filters.After != "" || filters.Page > 1 {
+				metadata.PrevCursor = prev
+			}
+		}
+	}
+
 	return movies, metadata, nil
 }
 
+// count() общее количество фильмов под фильтр, для навигации по курсору отдельным запросом
+func (m MovieModel) count(ctx context.Context, movieFilters MovieFilters) (int, error) {
+	w, _ := movieFilters.where()
+
+	query := `
+		SELECT count(*)
+		FROM movies
+		` + w.String()
+
+	var total int
+
+	err := m.DB.QueryRowContext(ctx, query, w.args...).Scan(&total)
+	return total, err
+}
+
+// Facet значение и сколько фильмов под текущими фильтрами его имеют
+type Facet struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// facetQueries группировки для фасетов, ключ - имя в параметре facets
+var facetQueries = map[string]string{
+	// фильтр по жанрам идет по GIN индексу, разворачиваем массив только у отобранных строк
+	"genres": `
+		SELECT genre, count(*)
+		FROM movies CROSS JOIN LATERAL unnest(genres) AS genre
+		%s
+		GROUP BY genre
+		ORDER BY count(*) DESC, genre`,
+	"decade": `
+		SELECT year / 10 * 10 AS decade, count(*)
+		FROM movies
+		%s
+		GROUP BY decade
+		ORDER BY decade`,
+}
+
+// FacetSafelist какие фасеты можно запросить
+var FacetSafelist = []string{"genres", "decade"}
+
+// Facets() количество фильмов по жанрам и десятилетиям с учетом фильтров выборки
+func (m MovieModel) Facets(movieFilters MovieFilters, names []string) (map[string][]Facet, error) {
+	w, _ := movieFilters.where()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	facets := make(map[string][]Facet, len(names))
+
+	for _, name := range names {
+		query, ok := facetQueries[name]
+		if !ok {
+			return nil, fmt.Errorf("неизвестный фасет: %s", name)
+		}
+
+		rows, err := m.DB.QueryContext(ctx, fmt.Sprintf(query, w), w.args...)
+		if err != nil {
+			return nil, err
+		}
+
+		values := []Facet{}
+
+		for rows.Next() {
+			var facet Facet
+
+			err := rows.Scan(&facet.Value, &facet.Count)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+
+			values = append(values, facet)
+		}
+
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		facets[name] = values
+	}
+
+	return facets, nil
+}
+
+// movieCursor() курсор на фильм для колонки сортировки column
+func movieCursor(sort, column string, movie *Movie) cursor {
+	var value string
+
+	switch column {
+	case "title":
+		value = movie.Title
+	case "year":
+		value = strconv.Itoa(int(movie.Year))
+	case "runtime":
+		value = strconv.Itoa(int(movie.Runtime))
+	default:
+		value = strconv.FormatInt(movie.ID, 10)
+	}
+
+	return cursor{Sort: sort, Value: value, ID: movie.ID}
+}
+
+func invert(op string) string {
+	if op == ">" {
+		return "<"
+	}
+	return ">"
+}
+
+func invertDirection(direction string) string {
+	if direction == "DESC" {
+		return "ASC"
+	}
+	return "DESC"
+}
+
+// GetAllForOwner() все фильмы, заведенные пользователем
+func (m MovieModel) GetAllForOwner(userID int64) ([]*Movie, error) {
+	query := `
+		SELECT id, created_at, title, year, runtime, genres, language::text, created_by, version
+		FROM movies
+		WHERE created_by = $1
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Language,
+			&movie.CreatedBy,
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+// IsEditor() дал ли владелец пользователю право редактировать фильм
+func (m MovieModel) IsEditor(movieID, userID int64) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM movies_editors WHERE movie_id = $1 AND user_id = $2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, userID).Scan(&exists)
+	return exists, err
+}
+
+// GetEditors() с кем поделились правом редактирования фильма
+func (m MovieModel) GetEditors(movieID int64) ([]*MovieEditor, error) {
+	query := `
+		SELECT users.id, users.name, movies_editors.created_at
+		FROM movies_editors
+		INNER JOIN users ON users.id = movies_editors.user_id
+		WHERE movies_editors.movie_id = $1
+		ORDER BY movies_editors.created_at, users.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	editors := []*MovieEditor{}
+
+	for rows.Next() {
+		var editor MovieEditor
+
+		err := rows.Scan(&editor.UserID, &editor.Name, &editor.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		editors = append(editors, &editor)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return editors, nil
+}
+
+// AddEditor() делимся правом редактирования, повторная выдача ничего не меняет
+func (m MovieModel) AddEditor(movieID, userID int64) error {
+	query := `
+		INSERT INTO movies_editors (movie_id, user_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, movieID, userID)
+	return err
+}
+
+// RemoveEditor() забираем право редактирования
+func (m MovieModel) RemoveEditor(movieID, userID int64) error {
+	query := `
+		DELETE FROM movies_editors
+		WHERE movie_id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, movieID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
 // type MockMovieModel struct{}
 
 // func (m MockMovieModel) Insert(movie *Movie) error {
@@ -0,0 +1,114 @@
+package data
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// argon2Params параметры argon2id, кодируются в сам хеш, поэтому их можно менять:
+// старые хеши проверяются со своими параметрами и пересчитываются при следующем входе
+type argon2Params struct {
+	memory      uint32 // KiB
+	iterations  uint32
+	parallelism uint8
+	saltLength  uint32
+	keyLength   uint32
+}
+
+// текущие параметры - второй рекомендованный вариант из RFC 9106
+var currentArgon2 = argon2Params{
+	memory:      64 * 1024,
+	iterations:  3,
+	parallelism: 4,
+	saltLength:  16,
+	keyLength:   32,
+}
+
+var (
+	argon2Prefix = []byte("$argon2id$")
+
+	errInvalidArgon2Hash = errors.New("некорректный хеш argon2id")
+)
+
+// hashArgon2() хеш в PHC формате $argon2id$v=19$m=65536,t=3,p=4$соль$хеш
+func hashArgon2(plaintext string, p argon2Params) ([]byte, error) {
+	salt := make([]byte, p.saltLength)
+
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey([]byte(plaintext), salt, p.iterations, p.memory, p.parallelism, p.keyLength)
+
+	encoded := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		p.memory,
+		p.iterations,
+		p.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+
+	return []byte(encoded), nil
+}
+
+// decodeArgon2() разбираем PHC строку на параметры, соль и хеш
+func decodeArgon2(hash []byte) (argon2Params, []byte, []byte, error) {
+	var p argon2Params
+
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, errInvalidArgon2Hash
+	}
+
+	var version int
+
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return p, nil, nil, errInvalidArgon2Hash
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.iterations, &p.parallelism)
+	if err != nil {
+		return p, nil, nil, errInvalidArgon2Hash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, errInvalidArgon2Hash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, errInvalidArgon2Hash
+	}
+
+	p.saltLength = uint32(len(salt))
+	p.keyLength = uint32(len(key))
+
+	return p, salt, key, nil
+}
+
+// matchesArgon2() пересчитываем хеш с параметрами из сохраненного и сравниваем за постоянное время
+func matchesArgon2(hash []byte, plaintext string) (bool, error) {
+	p, salt, key, err := decodeArgon2(hash)
+	if err != nil {
+		return false, err
+	}
+
+	other := argon2.IDKey([]byte(plaintext), salt, p.iterations, p.memory, p.parallelism, p.keyLength)
+
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func isArgon2(hash []byte) bool {
+	return bytes.HasPrefix(hash, argon2Prefix)
+}
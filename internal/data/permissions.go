@@ -3,35 +3,117 @@ package data
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
+	"gl_api.malyshev.io/internal/validator"
 )
 
+var (
+	ErrDuplicatePermission = errors.New("дубль права в базе")
+
+	// PermissionRX код права в виде ресурс:действие, любая из частей может быть шаблоном *
+	PermissionRX = regexp.MustCompile(`^([a-z0-9_-]+|\*):([a-z0-9_-]+|\*)$`)
+)
+
+// PermissionImplications какие права автоматически дают другие права, например запись подразумевает чтение.
+// Граф проходится транзитивно, ключи и значения - конкретные коды без шаблонов
+var PermissionImplications = map[string][]string{
+	"movies:admin": {"movies:write"},
+	"movies:write": {"movies:read"},
+}
+
+func ValidatePermissionCode(v *validator.Validator, code string) {
+	v.Check(code != "", "code", "обязательное поле")
+	v.Check(len(code) <= 100, "code", "поле превышает 100 байт")
+	v.Check(validator.Matches(code, PermissionRX), "code", "должно быть в формате ресурс:действие")
+}
+
+// MatchPermission() подходит ли код под выданное право: точное совпадение или * вместо ресурса или действия
+func MatchPermission(granted, code string) bool {
+	grantedResource, grantedAction, ok := strings.Cut(granted, ":")
+	if !ok {
+		return granted == code
+	}
+
+	resource, action, ok := strings.Cut(code, ":")
+	if !ok {
+		return false
+	}
+
+	return (grantedResource == "*" || grantedResource == resource) && (grantedAction == "*" || grantedAction == action)
+}
+
 // хранилище прав - слайс
 type Permissions []string
 
-// Include method - содержит ли хранилище искомое право
+// Include method - покрывает ли хранилище искомое право: напрямую, шаблоном или через PermissionImplications
 func (p Permissions) Include(code string) bool {
+	return p.include(code, map[string]bool{})
+}
+
+func (p Permissions) include(code string, seen map[string]bool) bool {
+	// seen защищает от циклов в графе подразумеваемых прав
+	if seen[code] {
+		return false
+	}
+	seen[code] = true
+
 	for i := range p {
-		if code == p[i] {
+		if MatchPermission(p[i], code) {
 			return true
 		}
 	}
+
+	// ищем права, которые подразумевают искомое, и проверяем их
+	for implying, implied := range PermissionImplications {
+		for _, c := range implied {
+			if c == code && p.include(implying, seen) {
+				return true
+			}
+		}
+	}
+
 	return false
 }
 
+// Intersect method - оставляем только те права из p, которые есть в other
+func (p Permissions) Intersect(other Permissions) Permissions {
+	permissions := Permissions{}
+
+	for i := range p {
+		if other.Include(p[i]) {
+			permissions = append(permissions, p[i])
+		}
+	}
+	return permissions
+}
+
 type PermissionModel struct {
-	DB *sql.DB
+	DB    *sql.DB
+	Cache *AuthCache
 }
 
 func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+	if permissions, ok := m.Cache.permissionsFor(userID); ok {
+		return permissions, nil
+	}
+
+	// действующие права - выданные напрямую плюс права всех ролей пользователя
 	query := `
 	SELECT permissions.code
 	FROM permissions
 	INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-	INNER JOIN users ON users_permissions.user_id = users.id
-	WHERE users.id = $1`
+	WHERE users_permissions.user_id = $1
+	UNION
+	SELECT permissions.code
+	FROM permissions
+	INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+	INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+	WHERE users_roles.user_id = $1`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -59,18 +141,150 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 		return nil, err
 	}
 
+	m.Cache.setPermissions(userID, permissions)
+
+	return permissions, nil
+}
+
+// GetAll method все существующие коды прав
+func (m PermissionModel) GetAll() (Permissions, error) {
+	query := `
+	SELECT code
+	FROM permissions
+	ORDER BY code`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := Permissions{}
+
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
 	return permissions, nil
 }
 
-// AddForUser method добавляет права выбранному пользователю
+// Insert method заводим новый код права
+func (m PermissionModel) Insert(code string) error {
+	query := `
+	INSERT INTO permissions (code)
+	VALUES ($1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, code)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "permissions_code_key"`:
+			return ErrDuplicatePermission
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Missing method какие из переданных кодов не заведены в базе
+func (m PermissionModel) Missing(codes ...string) ([]string, error) {
+	query := `
+	SELECT requested.code
+	FROM unnest($1::text[]) AS requested(code)
+	WHERE NOT EXISTS (SELECT 1 FROM permissions WHERE permissions.code = requested.code)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(codes))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var missing []string
+
+	for rows.Next() {
+		var code string
+
+		err := rows.Scan(&code)
+		if err != nil {
+			return nil, err
+		}
+
+		missing = append(missing, code)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return missing, nil
+}
+
+// AddForUser method добавляет права выбранному пользователю, уже выданные права пропускаются
 func (m PermissionModel) AddForUser(UserID int64, codes ...string) error {
 	query := `
 	INSERT INTO users_permissions
-	SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+	SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+	ON CONFLICT DO NOTHING`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, UserID, pq.Array(codes))
-	return err
+	if err != nil {
+		return err
+	}
+
+	m.Cache.dropPermissions(UserID)
+
+	return nil
+}
+
+// RemoveForUser method отзывает права у выбранного пользователя
+func (m PermissionModel) RemoveForUser(userID int64, codes ...string) error {
+	query := `
+	DELETE FROM users_permissions
+	WHERE user_id = $1
+	AND permission_id IN (SELECT id FROM permissions WHERE code = ANY($2))`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	m.Cache.dropPermissions(userID)
+
+	return nil
 }
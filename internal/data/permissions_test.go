@@ -0,0 +1,126 @@
+package data
+
+import "testing"
+
+func TestMatchPermission(t *testing.T) {
+	tests := []struct {
+		name    string
+		granted string
+		code    string
+		want    bool
+	}{
+		{"exact", "movies:read", "movies:read", true},
+		{"different action", "movies:read", "movies:write", false},
+		{"different resource", "movies:read", "users:read", false},
+		{"resource wildcard", "movies:*", "movies:write", true},
+		{"resource wildcard other resource", "movies:*", "users:write", false},
+		{"action wildcard", "*:read", "users:read", true},
+		{"action wildcard other action", "*:read", "users:write", false},
+		{"full wildcard", "*:*", "users:admin", true},
+		{"wildcard only in grant", "movies:read", "movies:*", false},
+		{"malformed grant", "movies", "movies:read", false},
+		{"malformed code", "movies:*", "movies", false},
+		{"prefix is not a match", "movie:read", "movies:read", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchPermission(tt.granted, tt.code); got != tt.want {
+				t.Errorf("MatchPermission(%q, %q) = %t, want %t", tt.granted, tt.code, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPermissionsInclude(t *testing.T) {
+	tests := []struct {
+		name         string
+		implications map[string][]string
+		permissions  Permissions
+		code         string
+		want         bool
+	}{
+		{"empty", nil, Permissions{}, "movies:read", false},
+		{"exact", nil, Permissions{"movies:read"}, "movies:read", true},
+		{"no match", nil, Permissions{"movies:read"}, "movies:write", false},
+		{"wildcard among others", nil, Permissions{"users:admin", "movies:*"}, "movies:write", true},
+		{"action wildcard", nil, Permissions{"*:read"}, "movies:read", true},
+		{
+			"direct implication",
+			map[string][]string{"movies:write": {"movies:read"}},
+			Permissions{"movies:write"}, "movies:read", true,
+		},
+		{
+			"implication is one way",
+			map[string][]string{"movies:write": {"movies:read"}},
+			Permissions{"movies:read"}, "movies:write", false,
+		},
+		{
+			"transitive implication",
+			map[string][]string{"movies:admin": {"movies:write"}, "movies:write": {"movies:read"}},
+			Permissions{"movies:admin"}, "movies:read", true,
+		},
+		{
+			"implication through wildcard grant",
+			map[string][]string{"movies:write": {"movies:read"}},
+			Permissions{"*:write"}, "movies:read", true,
+		},
+		{
+			"cycle without grant",
+			map[string][]string{"a:x": {"a:y"}, "a:y": {"a:x"}},
+			Permissions{"b:x"}, "a:x", false,
+		},
+		{
+			"cycle with grant",
+			map[string][]string{"a:x": {"a:y"}, "a:y": {"a:x"}},
+			Permissions{"a:y"}, "a:x", true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := PermissionImplications
+			PermissionImplications = tt.implications
+			defer func() { PermissionImplications = saved }()
+
+			if got := tt.permissions.Include(tt.code); got != tt.want {
+				t.Errorf("%v.Include(%q) = %t, want %t", tt.permissions, tt.code, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPermissionsIntersect(t *testing.T) {
+	saved := PermissionImplications
+	PermissionImplications = map[string][]string{"movies:write": {"movies:read"}}
+	defer func() { PermissionImplications = saved }()
+
+	tests := []struct {
+		name  string
+		p     Permissions
+		other Permissions
+		want  Permissions
+	}{
+		{"disjoint", Permissions{"movies:read"}, Permissions{"users:admin"}, Permissions{}},
+		{"exact", Permissions{"movies:read", "movies:write"}, Permissions{"movies:read"}, Permissions{"movies:read"}},
+		{"covered by wildcard", Permissions{"movies:write"}, Permissions{"movies:*"}, Permissions{"movies:write"}},
+		{"covered by implication", Permissions{"movies:read"}, Permissions{"movies:write"}, Permissions{"movies:read"}},
+		{"wildcard is not narrowed", Permissions{"movies:*"}, Permissions{"movies:read"}, Permissions{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.p.Intersect(tt.other)
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("%v.Intersect(%v) = %v, want %v", tt.p, tt.other, got, tt.want)
+			}
+
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("%v.Intersect(%v) = %v, want %v", tt.p, tt.other, got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"time"
 
 	"gl_api.malyshev.io/internal/validator"
@@ -14,14 +15,38 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeRefresh        = "refresh"
+	ScopeAPIKey         = "api-key"
+	ScopeEmailChange    = "email-change"
+	ScopeDeletion       = "account-deletion"
 )
 
+// ErrTokenReused повторное использование уже обмененного токена обновления - признак кражи токена
+var ErrTokenReused = errors.New("token reused")
+
 type Token struct {
 	Plaintext string    `json:"token"`
 	Hash      []byte    `json:"-"`
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	IP        string    `json:"-"`
+	UserAgent string    `json:"-"`
+	Family    []byte    `json:"-"` // общий идентификатор токенов одной сессии, переживает ротацию
+	// Impersonator администратор, который вошел от имени пользователя, nil - обычный токен
+	Impersonator *int64 `json:"-"`
+}
+
+// Session - активный токен аутентификации с метаданными, то что видит пользователь в списке сессий
+type Session struct {
+	ID        int64      `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	Expiry    time.Time  `json:"expiry"`
+	LastUsed  *time.Time `json:"last_used"`
+	IP        string     `json:"ip"`
+	UserAgent string     `json:"user_agent"`
+	Current   bool       `json:"current"`
 }
 
 func generateToken(userID int64, ttl time.Duration, score string) (*Token, error) {
@@ -53,7 +78,8 @@ func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 }
 
 type TokenModel struct {
-	DB *sql.DB
+	DB    *sql.DB
+	Cache *AuthCache
 }
 
 func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -66,15 +92,99 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
-func (m TokenModel) Insert(token *Token) error {
-	query := `INSERT INTO tokens (hash, user_id, expiry, scope)
-	VALUES ($1,  $2, $3, $4)`
-	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
+// NewSession() выдаем пару токенов одной сессии: короткоживущий токен доступа и токен обновления.
+// family == nil - новая сессия, иначе ротация внутри существующего семейства
+func (m TokenModel) NewSession(userID int64, accessTTL, refreshTTL time.Duration, family []byte, ip, userAgent string) (*Token, *Token, error) {
+	rotation := family != nil
+
+	if family == nil {
+		family = make([]byte, 16)
+
+		_, err := rand.Read(family)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	access, err := generateToken(userID, accessTTL, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refresh, err := generateToken(userID, refreshTTL, ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// оба токена пишем в одной транзакции, чтобы не остался токен доступа без пары
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	// при ротации прежний токен доступа семейства больше не нужен: сессия одна, и в списке сессий она должна быть одной строкой
+	if rotation {
+		_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family = $1 AND scope = $2`, family, ScopeAuthentication)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, token := range []*Token{access, refresh} {
+		token.Family = family
+		token.IP = ip
+		token.UserAgent = userAgent
+
+		_, err = tx.ExecContext(ctx, insertTokenQuery, token.insertArgs()...)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
 
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if rotation {
+		m.Cache.dropUser(userID)
+	}
+
+	return access, refresh, nil
+}
+
+// NewImpersonation() короткоживущий токен аутентификации для входа администратора от имени пользователя.
+// Токен обновления не выдается, продлить такой вход нельзя
+func (m TokenModel) NewImpersonation(userID, impersonatorID int64, ttl time.Duration, ip, userAgent string) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Impersonator = &impersonatorID
+	token.IP = ip
+	token.UserAgent = userAgent
+
+	err = m.Insert(token)
+	return token, err
+}
+
+const insertTokenQuery = `INSERT INTO tokens (hash, user_id, expiry, scope, ip, user_agent, family, impersonator_id)
+	VALUES ($1,  $2, $3, $4, $5, $6, $7, $8)`
+
+func (token *Token) insertArgs() []interface{} {
+	return []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, token.IP, token.UserAgent, token.Family, token.Impersonator}
+}
+
+func (m TokenModel) Insert(token *Token) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, args...)
+	_, err := m.DB.ExecContext(ctx, insertTokenQuery, token.insertArgs()...)
 	return err
 }
 
@@ -86,5 +196,314 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
+	if err != nil {
+		return err
+	}
+
+	m.Cache.dropUser(userID)
+
+	return nil
+}
+
+// Delete() удаляет конкретный токен по его открытому значению вместе со всем его семейством
+func (m TokenModel) Delete(scope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+	WITH target AS (
+		SELECT hash, family FROM tokens WHERE scope = $1 AND hash = $2
+	)
+	DELETE FROM tokens
+	WHERE hash IN (SELECT hash FROM target)
+	OR family IN (SELECT family FROM target WHERE family IS NOT NULL)
+	RETURNING user_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	deleted, err := m.deleteReturningUsers(ctx, query, scope, tokenHash[:])
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// deleteReturningUsers() выполняем DELETE ... RETURNING user_id и сбрасываем кеш затронутых пользователей
+func (m TokenModel) deleteReturningUsers(ctx context.Context, query string, args ...interface{}) (int, error) {
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	deleted := 0
+
+	for rows.Next() {
+		var userID int64
+
+		err := rows.Scan(&userID)
+		if err != nil {
+			return 0, err
+		}
+
+		m.Cache.dropUser(userID)
+		deleted++
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
+}
+
+// Touch() отмечаем время последнего использования токена, не чаще раза в минуту чтобы не нагружать базу
+func (m TokenModel) Touch(scope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	// в пределах минуты не ходим в базу вовсе
+	if !m.Cache.touch(scope, tokenHash) {
+		return nil
+	}
+
+	query := `UPDATE tokens
+	SET last_used = NOW()
+	WHERE scope = $1 AND hash = $2 AND (last_used IS NULL OR last_used < NOW() - INTERVAL '1 minute')`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, scope, tokenHash[:])
 	return err
 }
+
+// GetAllSessionsForUser() список активных сессий пользователя, currentToken помечается как текущая сессия
+func (m TokenModel) GetAllSessionsForUser(userID int64, currentToken string) ([]*Session, error) {
+	tokenHash := sha256.Sum256([]byte(currentToken))
+
+	query := `
+	SELECT id, created_at, expiry, last_used, ip, user_agent, hash = $3
+	FROM tokens
+	WHERE user_id = $1 AND scope = $2 AND expiry > $4 AND impersonator_id IS NULL
+	ORDER BY created_at DESC, id DESC`
+
+	args := []interface{}{userID, ScopeAuthentication, tokenHash[:], time.Now()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		var session Session
+
+		err := rows.Scan(
+			&session.ID,
+			&session.CreatedAt,
+			&session.Expiry,
+			&session.LastUsed,
+			&session.IP,
+			&session.UserAgent,
+			&session.Current,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// DeleteSession() отзываем одну сессию пользователя по ее id, вместе с ней отзываются и токены обновления
+func (m TokenModel) DeleteSession(userID, sessionID int64) error {
+	if sessionID < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+	WITH target AS (
+		SELECT hash, family FROM tokens WHERE id = $1 AND user_id = $2 AND scope = $3
+	)
+	DELETE FROM tokens
+	WHERE hash IN (SELECT hash FROM target)
+	OR family IN (SELECT family FROM target WHERE family IS NOT NULL)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, sessionID, userID, ScopeAuthentication)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	m.Cache.dropUser(userID)
+
+	return nil
+}
+
+// UseRefreshToken() обмениваем токен обновления: помечаем использованным и возвращаем его данные.
+// Если токен уже был использован, отзываем все семейство и возвращаем ErrTokenReused
+func (m TokenModel) UseRefreshToken(tokenPlaintext string) (*Token, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	// один UPDATE чтобы два параллельных запроса не смогли обменять один и тот же токен
+	query := `
+	UPDATE tokens
+	SET used_at = NOW()
+	WHERE hash = $1 AND scope = $2 AND used_at IS NULL AND expiry > $3
+	RETURNING user_id, expiry, family`
+
+	token := Token{
+		Plaintext: tokenPlaintext,
+		Hash:      tokenHash[:],
+		Scope:     ScopeRefresh,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], ScopeRefresh, time.Now()).Scan(
+		&token.UserID,
+		&token.Expiry,
+		&token.Family,
+	)
+	if err == nil {
+		return &token, nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	// токен не обменялся - проверим не был ли он уже использован раньше
+	query = `
+	SELECT family
+	FROM tokens
+	WHERE hash = $1 AND scope = $2 AND used_at IS NOT NULL`
+
+	var family []byte
+
+	err = m.DB.QueryRowContext(ctx, query, tokenHash[:], ScopeRefresh).Scan(&family)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = m.DeleteFamily(family)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, ErrTokenReused
+}
+
+// DeleteFamily() отзываем все токены одной сессии
+func (m TokenModel) DeleteFamily(family []byte) error {
+	if family == nil {
+		return nil
+	}
+
+	query := `DELETE FROM tokens
+	WHERE family = $1
+	RETURNING user_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.deleteReturningUsers(ctx, query, family)
+	return err
+}
+
+// NewEmailChange() токен подтверждения смены почты, новый адрес хранится рядом с токеном до подтверждения
+func (m TokenModel) NewEmailChange(userID int64, ttl time.Duration, email string) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeEmailChange)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// токен без адреса бесполезен, поэтому пишем их вместе
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, insertTokenQuery, token.insertArgs()...)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `INSERT INTO email_changes (hash, email)
+	VALUES ($1, $2)`
+
+	_, err = tx.ExecContext(ctx, query, token.Hash, email)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// GetEmailChange() новый адрес, ожидающий подтверждения этим токеном
+func (m TokenModel) GetEmailChange(tokenPlaintext string) (string, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `SELECT email
+	FROM email_changes
+	WHERE hash = $1`
+
+	var email string
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:]).Scan(&email)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+
+	return email, nil
+}
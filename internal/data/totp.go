@@ -0,0 +1,163 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// TOTPModel секреты двухфакторной аутентификации и одноразовые коды восстановления
+type TOTPModel struct {
+	DB *sql.DB
+}
+
+// SetSecret() сохраняем (уже зашифрованный) секрет, повторная регистрация перезаписывает старый
+func (m TOTPModel) SetSecret(userID int64, secret []byte) error {
+	query := `
+	INSERT INTO users_totp (user_id, secret)
+	VALUES ($1, $2)
+	ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_step = 0, created_at = NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, secret)
+	return err
+}
+
+// GetSecret() зашифрованный секрет пользователя
+func (m TOTPModel) GetSecret(userID int64) ([]byte, error) {
+	query := `
+	SELECT secret
+	FROM users_totp
+	WHERE user_id = $1`
+
+	var secret []byte
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return secret, nil
+}
+
+// UseStep() фиксируем принятый интервал, false - код из этого или более раннего интервала уже был использован
+func (m TOTPModel) UseStep(userID, step int64) (bool, error) {
+	query := `
+	UPDATE users_totp
+	SET last_step = $2
+	WHERE user_id = $1 AND last_step < $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// Delete() выключаем 2FA - удаляем секрет и коды восстановления
+func (m TOTPModel) Delete(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM users_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.DB.ExecContext(ctx, `DELETE FROM users_totp WHERE user_id = $1`, userID)
+	return err
+}
+
+// NewRecoveryCodes() генерируем новый набор кодов восстановления взамен старых, в базе храним только хеши
+func (m TOTPModel) NewRecoveryCodes(userID int64, n int) ([]string, error) {
+	codes := make([]string, n)
+	hashes := make([][]byte, n)
+
+	for i := range codes {
+		randomBytes := make([]byte, 5)
+
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(randomBytes))
+		codes[i] = code[:4] + "-" + code[4:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM users_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+	INSERT INTO users_recovery_codes (user_id, hash)
+	SELECT $1, unnest($2::bytea[])`
+
+	_, err = m.DB.ExecContext(ctx, query, userID, pq.Array(hashes))
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// UseRecoveryCode() код восстановления одноразовый - удаляем его при использовании
+func (m TOTPModel) UseRecoveryCode(userID int64, code string) (bool, error) {
+	query := `
+	DELETE FROM users_recovery_codes
+	WHERE user_id = $1 AND hash = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, hashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// hashRecoveryCode() код нормализуем, чтобы регистр и дефис при вводе не имели значения
+func hashRecoveryCode(code string) []byte {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+
+	hash := sha256.Sum256([]byte(normalized))
+	return hash[:]
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"gl_api.malyshev.io/internal/validator"
@@ -14,13 +15,15 @@ import (
 var AnonymousUser = &User{}
 
 type User struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	Password  password  `json:"-"` // "-" чтобы не вывести в json
-	Activated bool      `json:"activated"`
-	Version   int       `json:"-"` // "-" чтобы не вывести в json
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Name        string    `json:"name"`
+	Email       string    `json:"email"`
+	Password    password  `json:"-"` // "-" чтобы не вывести в json
+	Activated   bool      `json:"activated"`
+	TOTPEnabled bool      `json:"totp_enabled"` // включена ли двухфакторная аутентификация
+	Blocked     bool      `json:"blocked"`      // заблокирован администратором, вход и любые токены не действуют
+	Version     int       `json:"-"`            // "-" чтобы не вывести в json
 }
 
 type password struct {
@@ -28,9 +31,9 @@ type password struct {
 	hash      []byte
 }
 
-// Set() генерируем хеш пароля и записываем его в модель
+// Set() генерируем хеш пароля (argon2id) и записываем его в модель
 func (p *password) Set(plaintextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	hash, err := hashArgon2(plaintextPassword, currentArgon2)
 	if err != nil {
 		return err
 	}
@@ -40,8 +43,17 @@ func (p *password) Set(plaintextPassword string) error {
 	return nil
 }
 
-// Matches() проверяет совпадение хеша с паролем
+// Matches() проверяет совпадение хеша с паролем, понимает и argon2id, и старые хеши bcrypt
 func (p *password) Matches(plaintextPassword string) (bool, error) {
+	if isArgon2(p.hash) {
+		return matchesArgon2(p.hash, plaintextPassword)
+	}
+
+	// bcrypt молча обрезает пароль до 72 байт, такие длинные пароли с bcrypt хешем совпасть не могут
+	if len(plaintextPassword) > 72 {
+		return false, nil
+	}
+
 	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
 	if err != nil {
 		switch {
@@ -55,6 +67,20 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 	return true, nil
 }
 
+// NeedsRehash() хеш сделан устаревшим алгоритмом (bcrypt) или с другими параметрами argon2id
+func (p *password) NeedsRehash() bool {
+	if !isArgon2(p.hash) {
+		return true
+	}
+
+	params, _, _, err := decodeArgon2(p.hash)
+	if err != nil {
+		return true
+	}
+
+	return params != currentArgon2
+}
+
 func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(email != "", "email", "обязательное поле")
 	v.Check(validator.Matches(email, validator.EmailRX), "email", "поле должно быть валидным адресом почты")
@@ -63,7 +89,7 @@ func ValidateEmail(v *validator.Validator, email string) {
 func ValidatePasswordPlaintext(v *validator.Validator, password string) {
 	v.Check(password != "", "password", "не должно быть пустым")
 	v.Check(len(password) >= 8, "password", "поле должно быть больше 8 байт")
-	v.Check(len(password) <= 72, "password", "поле должно быть меньше 72 байт")
+	v.Check(len(password) <= 256, "password", "поле должно быть меньше 256 байт")
 }
 
 func ValidateUser(v *validator.Validator, user *User) {
@@ -85,7 +111,8 @@ var (
 )
 
 type UserModel struct {
-	DB *sql.DB
+	DB    *sql.DB
+	Cache *AuthCache
 }
 
 // Insert() вставка в users
@@ -113,10 +140,105 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
+// Get() пользователь по id
+func (m UserModel) Get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+	SELECT id, created_at, name, email, password_hash, activated, totp_enabled, blocked, version
+	FROM users
+	WHERE id = $1`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.TOTPEnabled,
+		&user.Blocked,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// GetAll() список пользователей для администрирования: поиск по имени и почте, фильтр по активации, пагинация
+func (m UserModel) GetAll(search string, activated *bool, filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, name, email, password_hash, activated, totp_enabled, blocked, version
+	FROM users
+	WHERE (STRPOS(LOWER(name), LOWER($1)) > 0 OR STRPOS(LOWER(email), LOWER($1)) > 0 OR $1 = '')
+	AND ($2::bool IS NULL OR activated = $2)
+	ORDER BY %s %s, id ASC
+	LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{search, activated, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.TOTPEnabled,
+			&user.Blocked,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return users, metadata, nil
+}
+
 // GetByEmail()
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-	SELECT id, created_at, name, email, password_hash, activated, version
+	SELECT id, created_at, name, email, password_hash, activated, totp_enabled, blocked, version
 	FROM users
 	WHERE email = $1`
 
@@ -132,6 +254,8 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.TOTPEnabled,
+		&user.Blocked,
 		&user.Version,
 	)
 
@@ -151,8 +275,8 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 func (m UserModel) Update(user *User) error {
 	query := `
 	UPDATE users
-	SET name=$1, email=$2, password_hash=$3, activated=$4, version=version+1
-	WHERE id=$5 AND version=$6
+	SET name=$1, email=$2, password_hash=$3, activated=$4, totp_enabled=$5, blocked=$6, version=version+1
+	WHERE id=$7 AND version=$8
 	RETURNING version`
 
 	args := []interface{}{
@@ -160,6 +284,8 @@ func (m UserModel) Update(user *User) error {
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.TOTPEnabled,
+		user.Blocked,
 		user.ID,
 		user.Version,
 	}
@@ -179,18 +305,30 @@ func (m UserModel) Update(user *User) error {
 		}
 	}
 
+	m.Cache.dropUser(user.ID)
+
 	return nil
 }
 
 func (m UserModel) GetForToken(tokenScope, TokenPlaintext string) (*User, error) {
+	user, _, err := m.GetForTokenWithImpersonator(tokenScope, TokenPlaintext)
+	return user, err
+}
+
+// GetForTokenWithImpersonator() пользователь по токену и id администратора, если токен выдан для входа от имени пользователя (иначе 0)
+func (m UserModel) GetForTokenWithImpersonator(tokenScope, TokenPlaintext string) (*User, int64, error) {
 	tokenHash := sha256.Sum256([]byte(TokenPlaintext))
 
+	if user, impersonatorID, ok := m.Cache.user(tokenScope, tokenHash); ok {
+		return user, impersonatorID, nil
+	}
+
 	query := `
-	SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+	SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.totp_enabled, users.blocked, users.version, tokens.expiry, COALESCE(tokens.impersonator_id, 0)
 	FROM users
 	INNER JOIN tokens
 	ON users.id = tokens.user_id
-	WHERE tokens.hash = $1 AND tokens.scope = $2 AND tokens.expiry > $3`
+	WHERE tokens.hash = $1 AND tokens.scope = $2 AND (tokens.expiry IS NULL OR tokens.expiry > $3)`
 
 	args := []interface{}{
 		tokenHash[:],
@@ -198,7 +336,11 @@ func (m UserModel) GetForToken(tokenScope, TokenPlaintext string) (*User, error)
 		time.Now(),
 	}
 
-	var user User
+	var (
+		user           User
+		expiry         *time.Time
+		impersonatorID int64
+	)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -210,19 +352,25 @@ func (m UserModel) GetForToken(tokenScope, TokenPlaintext string) (*User, error)
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.TOTPEnabled,
+		&user.Blocked,
 		&user.Version,
+		&expiry,
+		&impersonatorID,
 	)
 
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return nil, ErrRecordNotFound
+			return nil, 0, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, 0, err
 		}
 	}
 
-	return &user, nil
+	m.Cache.setUser(tokenScope, tokenHash, &user, impersonatorID, expiry)
+
+	return &user, impersonatorID, nil
 }
 
 func (u *User) IsAnonymous() bool {
@@ -0,0 +1,199 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// поддерживаемые алгоритмы подписи
+const (
+	AlgHS256 = "HS256"
+	AlgEdDSA = "EdDSA"
+)
+
+var (
+	ErrInvalidToken = errors.New("jwt: invalid token")
+	ErrExpiredToken = errors.New("jwt: token expired")
+	ErrUnknownKey   = errors.New("jwt: unknown key id")
+)
+
+// Key ключ подписи с идентификатором kid, для HS256 - общий секрет, для EdDSA - seed приватного ключа
+type Key struct {
+	ID        string
+	Algorithm string
+	secret    []byte
+	private   ed25519.PrivateKey
+	public    ed25519.PublicKey
+}
+
+// NewKey() собираем ключ из сырых байт, для EdDSA ожидается 32 байтовый seed
+func NewKey(id, algorithm string, raw []byte) (Key, error) {
+	key := Key{ID: id, Algorithm: algorithm}
+
+	switch algorithm {
+	case AlgHS256:
+		if len(raw) < 32 {
+			return Key{}, fmt.Errorf("jwt: ключ %q для HS256 должен быть не короче 32 байт", id)
+		}
+		key.secret = raw
+	case AlgEdDSA:
+		if len(raw) != ed25519.SeedSize {
+			return Key{}, fmt.Errorf("jwt: ключ %q для EdDSA должен быть %d байт", id, ed25519.SeedSize)
+		}
+		key.private = ed25519.NewKeyFromSeed(raw)
+		key.public = key.private.Public().(ed25519.PublicKey)
+	default:
+		return Key{}, fmt.Errorf("jwt: неподдерживаемый алгоритм %q", algorithm)
+	}
+
+	return key, nil
+}
+
+func (k Key) sign(signingInput []byte) []byte {
+	if k.Algorithm == AlgEdDSA {
+		return ed25519.Sign(k.private, signingInput)
+	}
+
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write(signingInput)
+	return mac.Sum(nil)
+}
+
+func (k Key) verify(signingInput, signature []byte) bool {
+	if k.Algorithm == AlgEdDSA {
+		return ed25519.Verify(k.public, signingInput, signature)
+	}
+
+	return hmac.Equal(k.sign(signingInput), signature)
+}
+
+// Claims полезная нагрузка токена
+type Claims struct {
+	Subject     string   `json:"sub"`
+	Session     string   `json:"sid,omitempty"`
+	Activated   bool     `json:"activated"`
+	Permissions []string `json:"permissions"`
+	IssuedAt    int64    `json:"iat"`
+	Expiry      int64    `json:"exp"`
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// Signer подписывает токены текущим ключом и проверяет любым из известных,
+// так ротация ключа - это добавление нового kid и смена текущего, старые токены доживают свой срок
+type Signer struct {
+	current string
+	keys    map[string]Key
+}
+
+func New(current string, keys ...Key) (*Signer, error) {
+	s := &Signer{
+		current: current,
+		keys:    make(map[string]Key),
+	}
+
+	for _, key := range keys {
+		s.keys[key.ID] = key
+	}
+
+	if _, ok := s.keys[current]; !ok {
+		return nil, fmt.Errorf("jwt: текущий ключ %q не найден среди ключей", current)
+	}
+
+	return s, nil
+}
+
+var encoding = base64.RawURLEncoding
+
+// Sign() подписываем claims текущим ключом
+func (s *Signer) Sign(claims Claims) (string, error) {
+	key := s.keys[s.current]
+
+	h, err := json.Marshal(header{Algorithm: key.Algorithm, Type: "JWT", KeyID: key.ID})
+	if err != nil {
+		return "", err
+	}
+
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encoding.EncodeToString(h) + "." + encoding.EncodeToString(c)
+	signature := key.sign([]byte(signingInput))
+
+	return signingInput + "." + encoding.EncodeToString(signature), nil
+}
+
+// Verify() проверяем подпись по kid из заголовка и срок действия на момент now
+func (s *Signer) Verify(token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	rawHeader, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var h header
+
+	err = json.Unmarshal(rawHeader, &h)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	key, ok := s.keys[h.KeyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	// алгоритм берем из ключа, а не из заголовка, иначе можно подменить alg
+	if h.Algorithm != key.Algorithm {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if !key.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrInvalidToken
+	}
+
+	rawClaims, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+
+	err = json.Unmarshal(rawClaims, &claims)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if now.Unix() >= claims.Expiry {
+		return nil, ErrExpiredToken
+	}
+
+	return &claims, nil
+}
+
+// Looks() похожа ли строка на JWT, чтобы отличить его от обычного токена
+func Looks(token string) bool {
+	return strings.Count(token, ".") == 2
+}
@@ -0,0 +1,169 @@
+package jwt
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustKey(t *testing.T, id, algorithm string, fill byte) Key {
+	t.Helper()
+
+	key, err := NewKey(id, algorithm, bytes.Repeat([]byte{fill}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func mustSigner(t *testing.T, current string, keys ...Key) *Signer {
+	t.Helper()
+
+	s, err := New(current, keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+// forge() токен с произвольным заголовком, подписанный ключом key
+func forge(t *testing.T, key Key, rawHeader string, claims Claims) string {
+	t.Helper()
+
+	s := mustSigner(t, key.ID, key)
+
+	token, err := s.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(token, ".")
+	signingInput := encoding.EncodeToString([]byte(rawHeader)) + "." + parts[1]
+
+	return signingInput + "." + encoding.EncodeToString(key.sign([]byte(signingInput)))
+}
+
+func TestSignVerify(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+
+	claims := Claims{
+		Subject:     "42",
+		Activated:   true,
+		Permissions: []string{"movies:read"},
+		IssuedAt:    now.Unix(),
+		Expiry:      now.Add(time.Minute).Unix(),
+	}
+
+	hs := mustKey(t, "hs-1", AlgHS256, 1)
+	ed := mustKey(t, "ed-1", AlgEdDSA, 2)
+	hsOld := mustKey(t, "hs-0", AlgHS256, 3)
+	hsOther := mustKey(t, "hs-1", AlgHS256, 4)
+
+	sign := func(s *Signer, c Claims) string {
+		token, err := s.Sign(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	expired := claims
+	expired.Expiry = now.Add(-time.Second).Unix()
+
+	tests := []struct {
+		name     string
+		verifier *Signer
+		token    string
+		err      error
+	}{
+		{
+			name:     "HS256 round-trip",
+			verifier: mustSigner(t, "hs-1", hs),
+			token:    sign(mustSigner(t, "hs-1", hs), claims),
+		},
+		{
+			name:     "EdDSA round-trip",
+			verifier: mustSigner(t, "ed-1", ed),
+			token:    sign(mustSigner(t, "ed-1", ed), claims),
+		},
+		{
+			name:     "retired key after rotation",
+			verifier: mustSigner(t, "hs-1", hs, hsOld),
+			token:    sign(mustSigner(t, "hs-0", hsOld), claims),
+		},
+		{
+			name:     "retired key removed",
+			verifier: mustSigner(t, "hs-1", hs),
+			token:    sign(mustSigner(t, "hs-0", hsOld), claims),
+			err:      ErrUnknownKey,
+		},
+		{
+			name:     "unknown kid",
+			verifier: mustSigner(t, "ed-1", ed),
+			token:    sign(mustSigner(t, "hs-1", hs), claims),
+			err:      ErrUnknownKey,
+		},
+		{
+			name:     "wrong secret for kid",
+			verifier: mustSigner(t, "hs-1", hs),
+			token:    sign(mustSigner(t, "hs-1", hsOther), claims),
+			err:      ErrInvalidToken,
+		},
+		{
+			name:     "alg mismatch",
+			verifier: mustSigner(t, "ed-1", ed),
+			token:    forge(t, ed, `{"alg":"HS256","typ":"JWT","kid":"ed-1"}`, claims),
+			err:      ErrInvalidToken,
+		},
+		{
+			name:     "alg none",
+			verifier: mustSigner(t, "hs-1", hs),
+			token:    strings.Join(strings.Split(forge(t, hs, `{"alg":"none","typ":"JWT","kid":"hs-1"}`, claims), ".")[:2], ".") + ".",
+			err:      ErrInvalidToken,
+		},
+		{
+			name:     "expired",
+			verifier: mustSigner(t, "hs-1", hs),
+			token:    sign(mustSigner(t, "hs-1", hs), expired),
+			err:      ErrExpiredToken,
+		},
+		{
+			name:     "tampered claims",
+			verifier: mustSigner(t, "hs-1", hs),
+			token: func() string {
+				parts := strings.Split(sign(mustSigner(t, "hs-1", hs), claims), ".")
+				parts[1] = encoding.EncodeToString([]byte(`{"sub":"1","exp":9999999999,"permissions":["*:*"]}`))
+				return strings.Join(parts, ".")
+			}(),
+			err: ErrInvalidToken,
+		},
+		{
+			name:     "malformed",
+			verifier: mustSigner(t, "hs-1", hs),
+			token:    "not.a.jwt",
+			err:      ErrInvalidToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.verifier.Verify(tt.token, now)
+
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.err)
+			}
+
+			if tt.err != nil {
+				return
+			}
+
+			if got.Subject != claims.Subject || got.Expiry != claims.Expiry || len(got.Permissions) != 1 {
+				t.Errorf("Verify() claims = %+v, want %+v", got, claims)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// параметры по умолчанию из RFC 6238, их же ждут все приложения-аутентификаторы
+const (
+	Period = 30
+	Digits = 6
+	// Skew сколько соседних интервалов принимаем, чтобы пережить рассинхрон часов
+	Skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret() новый случайный секрет длиной 160 бит, как рекомендует RFC 4226
+func GenerateSecret() ([]byte, error) {
+	secret := make([]byte, 20)
+
+	_, err := rand.Read(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+// EncodeSecret() секрет в base32 для ручного ввода в приложение
+func EncodeSecret(secret []byte) string {
+	return encoding.EncodeToString(secret)
+}
+
+// URI() ссылка otpauth:// для QR кода
+func URI(issuer, account string, secret []byte) string {
+	label := url.PathEscape(issuer + ":" + account)
+
+	params := url.Values{}
+	params.Set("secret", EncodeSecret(secret))
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(Digits))
+	params.Set("period", fmt.Sprint(Period))
+
+	// пробелы кодируем как %20, "+" в issuer некоторые приложения показывают как есть
+	return "otpauth://totp/" + label + "?" + strings.ReplaceAll(params.Encode(), "+", "%20")
+}
+
+// Step() номер 30 секундного интервала для момента t
+func Step(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// Code() одноразовый код для момента t
+func Code(secret []byte, t time.Time) string {
+	return code(secret, Step(t))
+}
+
+func code(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// динамическое усечение из RFC 4226
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", Digits, value%1_000_000)
+}
+
+// Validate() проверяем код на момент t с допуском Skew интервалов.
+// Возвращаем номер совпавшего интервала, чтобы вызывающий мог запретить повторное использование кода
+func Validate(secret []byte, passcode string, t time.Time) (int64, bool) {
+	if len(passcode) != Digits {
+		return 0, false
+	}
+
+	current := Step(t)
+
+	for i := int64(-Skew); i <= Skew; i++ {
+		step := current + i
+		if subtle.ConstantTimeCompare([]byte(code(secret, step)), []byte(passcode)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
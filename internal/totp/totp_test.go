@@ -0,0 +1,147 @@
+package totp
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+// секрет SHA1 из RFC 6238 Appendix B
+var rfcSecret = []byte("12345678901234567890")
+
+func TestCodeRFC6238(t *testing.T) {
+	// в RFC коды из 8 цифр, у нас 6 - это их младшие разряды
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		if got := Code(rfcSecret, time.Unix(tt.unix, 0)); got != tt.want {
+			t.Errorf("Code(T=%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	current := Step(now)
+
+	tests := []struct {
+		name     string
+		passcode string
+		ok       bool
+		step     int64
+	}{
+		{"current step", Code(rfcSecret, now), true, current},
+		{"previous step", Code(rfcSecret, now.Add(-Period*time.Second)), true, current - 1},
+		{"next step", Code(rfcSecret, now.Add(Period*time.Second)), true, current + 1},
+		{"outside skew window behind", Code(rfcSecret, now.Add(-2*Period*time.Second)), false, 0},
+		{"outside skew window ahead", Code(rfcSecret, now.Add(2*Period*time.Second)), false, 0},
+		{"wrong code", "000000", false, 0},
+		{"wrong length", "08180", false, 0},
+		{"empty", "", false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, tt.passcode, now)
+			if ok != tt.ok || step != tt.step {
+				t.Errorf("Validate(%q) = (%d, %t), want (%d, %t)", tt.passcode, step, ok, tt.step, tt.ok)
+			}
+		})
+	}
+}
+
+// повторное использование отсекает вызывающий, сравнивая шаг с последним принятым (users_totp.last_step),
+// поэтому Validate должен отдавать для одного и того же кода один и тот же шаг все время его жизни
+func TestValidateReplay(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	passcode := Code(rfcSecret, now)
+
+	lastStep, ok := Validate(rfcSecret, passcode, now)
+	if !ok {
+		t.Fatal("код не принят с первого раза")
+	}
+
+	for _, later := range []time.Duration{0, 10 * time.Second, Period * time.Second} {
+		step, ok := Validate(rfcSecret, passcode, now.Add(later))
+		if ok && step > lastStep {
+			t.Errorf("через %s код принят повторно с шагом %d > %d", later, step, lastStep)
+		}
+	}
+
+	// следующий код уже новым шагом проходит
+	next := now.Add(Period * time.Second)
+	step, ok := Validate(rfcSecret, Code(rfcSecret, next), next)
+	if !ok || step <= lastStep {
+		t.Errorf("следующий код = (%d, %t), want шаг > %d", step, ok, lastStep)
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+
+	ciphertext, err := Encrypt(key, rfcSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := Decrypt(key, ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(secret, rfcSecret) {
+		t.Errorf("Decrypt() = %q, want %q", secret, rfcSecret)
+	}
+
+	again, err := Encrypt(key, rfcSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(ciphertext, again) {
+		t.Error("Encrypt() дважды отдал одинаковый шифротекст, nonce не случайный")
+	}
+
+	tests := []struct {
+		name       string
+		key        []byte
+		ciphertext func() []byte
+	}{
+		{"tampered ciphertext", key, func() []byte {
+			c := bytes.Clone(ciphertext)
+			c[len(c)-1] ^= 1
+			return c
+		}},
+		{"tampered nonce", key, func() []byte {
+			c := bytes.Clone(ciphertext)
+			c[0] ^= 1
+			return c
+		}},
+		{"truncated", key, func() []byte { return ciphertext[:5] }},
+		{"wrong key", bytes.Repeat([]byte{8}, 32), func() []byte { return ciphertext }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decrypt(tt.key, tt.ciphertext())
+			if !errors.Is(err, ErrInvalidCiphertext) {
+				t.Errorf("Decrypt() error = %v, want %v", err, ErrInvalidCiphertext)
+			}
+		})
+	}
+
+	if _, err := Encrypt(key[:16], rfcSecret); err == nil {
+		t.Error("Encrypt() принял ключ короче 32 байт")
+	}
+}
@@ -0,0 +1,5 @@
+DELETE FROM tokens WHERE expiry IS NULL;
+
+ALTER TABLE tokens DROP COLUMN IF EXISTS permissions;
+ALTER TABLE tokens DROP COLUMN IF EXISTS name;
+ALTER TABLE tokens ALTER COLUMN expiry SET NOT NULL;
\ No newline at end of file
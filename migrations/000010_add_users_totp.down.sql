@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS users_recovery_codes;
+DROP TABLE IF EXISTS users_totp;
+
+ALTER TABLE users DROP COLUMN IF EXISTS totp_enabled;
\ No newline at end of file
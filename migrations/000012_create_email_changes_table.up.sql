@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS email_changes (
+    hash bytea PRIMARY KEY REFERENCES tokens ON DELETE CASCADE, -- токен подтверждения, отправленный на новый адрес
+    email citext NOT NULL
+);
\ No newline at end of file
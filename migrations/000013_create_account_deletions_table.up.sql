@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS account_deletions (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    delete_after timestamp(0) with time zone NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS account_deletions_delete_after_idx ON account_deletions (delete_after);
\ No newline at end of file
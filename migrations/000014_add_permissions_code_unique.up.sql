@@ -0,0 +1 @@
+ALTER TABLE permissions ADD CONSTRAINT permissions_code_key UNIQUE (code);
\ No newline at end of file
@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'movies:admin';
+DROP TABLE IF EXISTS movies_editors;
+ALTER TABLE movies DROP COLUMN IF EXISTS created_by;
\ No newline at end of file
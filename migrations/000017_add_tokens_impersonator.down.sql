@@ -0,0 +1 @@
+ALTER TABLE tokens DROP COLUMN IF EXISTS impersonator_id;
\ No newline at end of file
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS movies_title_language_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS language;
\ No newline at end of file
@@ -0,0 +1,11 @@
+-- язык названия задает конфигурацию полнотекстового поиска со стеммингом ('фильмы' = 'фильм')
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS language regconfig NOT NULL DEFAULT 'simple';
+
+-- так же, как data.DetectLanguage для новых фильмов
+UPDATE movies SET language = CASE
+    WHEN title ~ '[А-Яа-яЁё]' THEN 'russian'
+    WHEN title ~ '[A-Za-z]' THEN 'english'
+    ELSE 'simple'
+END::regconfig;
+
+CREATE INDEX IF NOT EXISTS movies_title_language_idx ON movies USING GIN (to_tsvector(language, title));
\ No newline at end of file
@@ -0,0 +1,2 @@
+-- блокировка администратором, в отличие от activated пользователь сам ее снять не может
+ALTER TABLE users ADD COLUMN IF NOT EXISTS blocked bool NOT NULL DEFAULT false;
\ No newline at end of file